@@ -6,15 +6,151 @@ import (
 	"encoding/binary"
 	_ "encoding/hex"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
+
+	log "github.com/sirupsen/logrus"
 )
 
 var (
 	ErrMoreData = errors.New("Not enough data")
+	ErrWrongApp = errors.New("Unexpected state of device: verify that the right application is opened?")
+
+	ErrUserDenied     = errors.New("Operation denied by the user")
+	ErrBelowWatermark = errors.New("Level is below safety watermark")
+
+	ErrIncorrectParams = errors.New("Incorrect parameters received P1/P2")
+	ErrWrongLength     = errors.New("Wrong length")
+	ErrUnsupportedIns  = errors.New("Unsupported Instruction")
+
+	ErrResponseTooLarge = errors.New("Response exceeds maximum size")
+	ErrRequestTooLarge  = errors.New("Request exceeds maximum size")
+
+	ErrAborted = errors.New("Exchange aborted")
+
+	ErrDeviceLocked = errors.New("Device is locked; enter the PIN to unlock")
+
+	ErrTimeout = errors.New("Timeout Expired")
 )
 
+const (
+	// Delay between attempts when retrying a failed HID read
+	readRetryDelay = 20 * time.Millisecond
+
+	// Bounds on discarding stale frames before a new exchange
+	maxDrainFrames = 32
+	drainTimeoutMs = 1
+
+	// Longest wait for a frame from the device, such as while the user is prompted
+	readTimeout = 50 * time.Second
+
+	// Interval at which a pending read checks for a deadline or Abort()
+	readPollInterval = 100 * time.Millisecond
+
+	// Delay before the first retry of a request which failed with a transient
+	// status; doubled for each subsequent retry
+	transientRetryDelay = 100 * time.Millisecond
+)
+
+// Pool of buffers for reading frames from the device, to reduce garbage
+// when reading large, multi-frame responses
+var framePool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, hidPacketSize)
+		return &b
+	},
+}
+
+// Returns a zeroed buffer of size bytes from the pool
+func getFrame(size int) *[]byte {
+
+	frame := framePool.Get().(*[]byte)
+	if cap(*frame) < size {
+		b := make([]byte, size)
+		return &b
+	}
+
+	*frame = (*frame)[:size]
+	for i := range *frame {
+		(*frame)[i] = 0
+	}
+
+	return frame
+}
+
+// Returns a buffer to the pool. The caller must not use it afterwards.
+func putFrame(frame *[]byte) {
+	framePool.Put(frame)
+}
+
+// Returned from unwrapResponseAPDU when the device reports a 0x61xx status,
+// indicating this many more bytes are available using GET RESPONSE
+type moreDataAvailable int
+
+func (m moreDataAvailable) Error() string {
+	return fmt.Sprintf("%d more bytes available", int(m))
+}
+
+// StatusError is returned by Read() when the device answers with a status word
+// indicating failure. It unwraps to the matching sentinel, ie: ErrUserDenied.
+type StatusError struct {
+	Status int
+	Err    error
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// Returns the message along with the raw status word, for logging
+func (e *StatusError) String() string {
+	return fmt.Sprintf("%s (0x%04x)", e.Err, e.Status)
+}
+
+// TransportError is returned when the HID stack fails to write or read a frame. Unlike
+// a StatusError, which is a reply from the device, it means the device could not be
+// reached, so reconnecting may help. Check for it with errors.As().
+type TransportError struct {
+	Op     string // "write" or "read"
+	Serial string
+	Err    error
+}
+
+func (e *TransportError) Error() string {
+
+	preposition := "to"
+	if e.Op == "read" {
+		preposition = "from"
+	}
+
+	msg := fmt.Sprintf("Failed to %s %s device %s", e.Op, preposition, e.Serial)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+
+	return msg
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// ISO 7816-4 GET RESPONSE instruction, used to fetch the remaining
+// bytes of a response after the device reports a 0x61xx status
+type getResponseApdu struct {
+	Le uint8 // Number of bytes to fetch (0 = 256)
+}
+
+func (a getResponseApdu) MarshalBinary() ([]byte, error) {
+	return []byte{0x00, 0xC0, 0x00, 0x00, a.Le}, nil
+}
+
 // Interface to be implemented by sub-libraries, as the APDU struct will be
 // specific to each ledger application. This interface enforces the one required
 // function that 'Write' must call.
@@ -37,8 +173,24 @@ func (l *Ledger) Write(apdu Apdu, channel []byte) (int, error) {
 	}
 	//fmt.Println("HID =>", hex.EncodeToString(apduBytes))
 
+	// Refuse before anything is wrapped or written
+	if l.MaxRequestSize > 0 && len(apduBytes) > l.MaxRequestSize {
+		return 0, errors.Wrapf(ErrRequestTooLarge, "%d > %d bytes", len(apduBytes), l.MaxRequestSize)
+	}
+
+	// A new exchange clears any earlier Abort(); one made in the middle of
+	// a multi-APDU exchange ends it before the next APDU is sent
+	if l.multiPart == 0 {
+		l.clearAbort()
+	} else if l.isAborted() {
+		return 0, ErrAborted
+	}
+
+	// Discard anything left over from a previous, abandoned exchange
+	l.drain()
+
 	// Encode instruction + parameters
-	bufferBytes, err := l.wrapCommandAPDU(channel, apduBytes, 64)
+	bufferBytes, err := l.wrapCommandAPDU(channel, apduBytes, hidPacketSize)
 	if err != nil {
 		return 0, errors.Wrap(err, "Unable to wrap APDU instruction")
 	}
@@ -48,81 +200,273 @@ func (l *Ledger) Write(apdu Apdu, channel []byte) (int, error) {
 	// Write to device
 	b, err := l.Dev.Write(bufferBytes)
 	if b <= 0 {
-		return 0, errors.Wrap(err, "Failed to write")
+		return 0, &TransportError{"write", l.Serial(), err}
 	}
 	//fmt.Println("Wrote bytes:", b)
+
+	// Kept in case Read() needs to retry the request
+	l.lastRequest = bufferBytes
 	
 	return b, nil
 }
 
+// Discards any frames waiting in the device's buffer, such as a late reply to a
+// read which timed out, which would otherwise corrupt the next exchange.
+// Reads are non-blocking and bounded by maxDrainFrames.
+func (l *Ledger) drain() {
+
+	frame := getFrame(hidPacketSize)
+	defer putFrame(frame)
+
+	for i := 0; i < maxDrainFrames; i++ {
+
+		b, err := l.Dev.ReadTimeout(*frame, drainTimeoutMs)
+		if err != nil || b <= 0 {
+			return
+		}
+
+		log.WithField("Serial", l.Serial()).Debug("Discarded stale frame")
+	}
+}
+
+// Reads the response to the last request written. If the device answers with one of
+// TransientStatuses, the request is written again and its response read, up to
+// TransientRetries times, backing off exponentially between attempts. Requests which
+// are part of a multi-APDU exchange are not retried; see BeginMultiPart().
+// Returns byte slice or error
+func (l *Ledger) Read(channel []byte) ([]byte, error) {
+	return l.ReadContext(context.Background(), channel)
+}
+
+// Same as Read(), but gives up waiting on the device when ctx is done, such as to
+// impose a shorter deadline than the internal one on a user prompt. Returns
+// ErrTimeout if either deadline passes, or ctx's error if it is cancelled.
+func (l *Ledger) ReadContext(ctx context.Context, channel []byte) ([]byte, error) {
+
+	delay := transientRetryDelay
+
+	for attempt := 0; ; attempt++ {
+
+		resp, err := l.readResponse(ctx, channel)
+		if err == nil || attempt >= l.TransientRetries || !l.isTransient(err) || l.lastRequest == nil || l.multiPart > 0 {
+			return resp, err
+		}
+
+		log.WithError(err).WithField("Serial", l.Serial()).Debug("Transient failure; retrying request")
+
+		time.Sleep(delay)
+		delay = delay * 2
+
+		if b, err := l.Dev.Write(l.lastRequest); b <= 0 {
+			return nil, &TransportError{"write", l.Serial(), err}
+		}
+	}
+}
+
+// Makes the Read() waiting on the device return ErrAborted, such as when the user is
+// being prompted for a request which is no longer wanted. The request is remembered
+// until the next exchange begins, so an Abort() made just before Read() starts waiting
+// is not lost. The aborted Read() closes and reopens the HID handle, so that the
+// device's reply to the abandoned request cannot reach a later exchange.
+func (l *Ledger) Abort() {
+
+	l.abortMu.Lock()
+	defer l.abortMu.Unlock()
+
+	if l.abortCh == nil {
+		l.abortCh = make(chan struct{})
+	}
+
+	if !l.aborted {
+		close(l.abortCh)
+		l.aborted = true
+	}
+}
+
+// Helper function returning the channel closed by Abort()
+func (l *Ledger) abortChan() chan struct{} {
+
+	l.abortMu.Lock()
+	defer l.abortMu.Unlock()
+
+	if l.abortCh == nil {
+		l.abortCh = make(chan struct{})
+	}
+
+	return l.abortCh
+}
+
+// Helper function reporting whether Abort() was called during this exchange
+func (l *Ledger) isAborted() bool {
+
+	l.abortMu.Lock()
+	defer l.abortMu.Unlock()
+
+	return l.aborted
+}
+
+// Helper function forgetting an Abort() made during a previous exchange
+func (l *Ledger) clearAbort() {
+
+	l.abortMu.Lock()
+	defer l.abortMu.Unlock()
+
+	if l.aborted {
+		l.abortCh = nil
+		l.aborted = false
+	}
+}
+
+// Helper function replacing the HID handle with a freshly opened one, after the
+// exchange on it was aborted. Does nothing for a Ledger not created by Get().
+func (l *Ledger) reopen() {
+
+	if l.open == nil {
+		return
+	}
+
+	if err := l.Dev.Close(); err != nil {
+		log.WithError(err).WithField("Serial", l.Serial()).Debug("Failed to close aborted device")
+	}
+
+	dev, err := l.open()
+	if err != nil {
+		log.WithError(err).WithField("Serial", l.Serial()).Warn("Failed to reopen aborted device")
+		return
+	}
+
+	l.Dev = dev
+}
+
+// Helper function to check whether err carries one of TransientStatuses
+func (l *Ledger) isTransient(err error) bool {
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+
+	for _, status := range l.TransientStatuses {
+		if statusErr.Status == status {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Reads bytes from the device's buffer, decodes the result and
 // checks for internal errors.
 // Returns byte slice or error
-func (l *Ledger) Read(channel []byte) ([]byte, error) {
+func (l *Ledger) readResponse(parent context.Context, channel []byte) ([]byte, error) {
 
 	var result []byte           // Holds raw bytes read from device
 	var unwrappedResult []byte  // Holds unwrapped/parsed result
 
-	// Helper function for reading 64 byte responses
-	readData := func() ([]byte, error) {
+	// Helper function for reading packet sized responses
+	// Appends the packet read to dst, returning the extended slice
+	readData := func(dst []byte) ([]byte, error) {
 
-		ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Second)
+		ctx, cancel := context.WithTimeout(parent, readTimeout)
 		defer cancel()
 
-		var err error
-		var r = make([]byte, 64)
+		abort := l.abortChan()
+
+		var attempts int
+
+		// Frame buffers are only used within this function, then returned to the pool
+		frame := getFrame(hidPacketSize)
+		defer putFrame(frame)
+		r := *frame
 
-		// Implements a waiter for first response from device
-		// If num bytes read is 0, sleep for a bit then try again
-		// After we read, we can return
-		for b := 0; b == 0; {
+		// Implements a waiter for the response from device
+		// If num bytes read is 0, check for abort or timeout, then try again
+		// Some HID stacks return a frame in pieces, so keep
+		// reading until a whole frame has been assembled
+		for got := 0; got < len(r); {
 			
-			// Read from device
-			b, err = l.Dev.Read(r)
-			if b < 0 {
-				return nil, errors.Wrap(err, "Failed to read")
+			// Read from device, waiting at most one poll interval, even
+			// if the handle is in blocking mode while the user is prompted.
+			// The HID library reports failures as an error with 0 bytes
+			// read, not as a negative count
+			start := time.Now()
+			b, err := l.Dev.ReadTimeout(r[got:], int(readPollInterval / time.Millisecond))
+			if err != nil || b < 0 {
+
+				// Some HID stacks report spurious failures which succeed
+				// on a subsequent attempt; retry a bounded number of times
+				if attempts < l.ReadRetries {
+					attempts++
+					time.Sleep(readRetryDelay)
+					continue
+				}
+
+				return nil, &TransportError{"read", l.Serial(), err}
 			}
 			
-			// If no bytes read, sleep  and repeat
+			// If no bytes read, wait out the rest of the interval and repeat
 			if b == 0 {
 				select{
 				case <-ctx.Done():
-					return nil, errors.New("Timeout Expired")
-				case <-time.After(100 * time.Millisecond):
+					if errors.Is(ctx.Err(), context.Canceled) {
+						return nil, ctx.Err()
+					}
+					return nil, ErrTimeout
+				case <-abort:
+					l.reopen()
+					return nil, ErrAborted
+				case <-time.After(readPollInterval - time.Since(start)):
 					continue
 				}
 			}
+
+			got += b
 		}
 		
-		// Return what was read
-		return r, nil
+		// Copy out what was read
+		return append(dst, r...), nil
 	}
 
 	// Read initial bytes
-	firstBytes, err := readData()
+	result, err := readData(result)
 	if err != nil {
 		return nil, err
 	}
-	result = append(result, firstBytes...)
 
 	// Decode initial result
 	// loop in case more data needs to be fetched
 	for moreData := true; moreData; {
 
-		unwrappedResult, err = l.unwrapResponseAPDU(channel, result, 64)
+		var remaining moreDataAvailable
+
+		unwrappedResult, err = l.unwrapResponseAPDU(channel, result, hidPacketSize)
 		if err != nil {
 
 			// Is more data needed?
 			if errors.Is(err, ErrMoreData) {
 
-				// Read another 64 bytes from device
-				moreBytes, err := readData()
+				// Don't let a misbehaving device stream frames at us forever
+				if l.MaxResponseSize > 0 && len(result) >= l.MaxResponseSize {
+					return nil, errors.Wrapf(ErrResponseTooLarge, "read %d bytes", len(result))
+				}
+
+				// Read another packet from device, appending
+				// additional data to main slice; loop and unwrap again
+				result, err = readData(result)
 				if err != nil {
 					return nil, err
 				}
 
-				// Append additional data to main slice; loop and unwrap again
-				result = append(result, moreBytes...)
+			} else if errors.As(err, &remaining) {
+
+				// Device holds more data than it returned; fetch the tail
+				tail, err := l.getResponse(parent, channel, remaining)
+				if err != nil {
+					return nil, err
+				}
+
+				unwrappedResult = append(unwrappedResult, tail...)
+				moreData = false
 
 			} else {
 
@@ -144,6 +488,30 @@ func (l *Ledger) Read(channel []byte) ([]byte, error) {
 	return unwrappedResult, nil
 }
 
+// Issues GET RESPONSE to fetch the remaining bytes of a response which
+// ended in a 0x61xx status. The reply may itself end in 0x61xx, in which
+// case readResponse() will fetch again. GET RESPONSE is never retried, and
+// the request it follows remains the one Read() retries.
+func (l *Ledger) getResponse(ctx context.Context, channel []byte, remaining moreDataAvailable) ([]byte, error) {
+
+	request := l.lastRequest
+	defer func() {
+		l.lastRequest = request
+	}()
+
+	_, err := l.Write(getResponseApdu{uint8(remaining)}, channel)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to write GET RESPONSE")
+	}
+
+	tail, err := l.readResponse(ctx, channel)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read GET RESPONSE")
+	}
+
+	return tail, nil
+}
+
 //
 // https://github.com/LedgerHQ/blue-loader-python/blob/bb7aeade0a7eed0c61a57482abc18cca9e97b253/ledgerblue/ledgerWrapper.py#L23
 func (l *Ledger) wrapCommandAPDU(channel []byte, command []byte, packetSize int) ([]byte, error) {
@@ -224,7 +592,9 @@ func (l *Ledger) unwrapResponseAPDU(channel []byte, data []byte, packetSize int)
 	offset := 0
 	extraHeaderSize := 2
 
-	if len(data) == 0 || (len(data) < 5 + extraHeaderSize + 5) {
+	// Only the header is required here; a bare status word with no payload is a
+	// valid, empty response, and a short payload is caught by the length check
+	if len(data) < 5 + extraHeaderSize {
 		return nil, errors.New("No data")
 	}
 
@@ -268,7 +638,10 @@ func (l *Ledger) unwrapResponseAPDU(channel []byte, data []byte, packetSize int)
 		blockSize = packetSize - 5 - extraHeaderSize
 	}
 
-	result := data[offset:offset+blockSize]
+	// Copy rather than slice data; appending continuation frames to
+	// a slice of data would overwrite the frames being decoded
+	result := make([]byte, 0, responseLength)
+	result = append(result, data[offset:offset+blockSize]...)
 	offset = offset + blockSize
 
 	// loop over data
@@ -276,8 +649,10 @@ func (l *Ledger) unwrapResponseAPDU(channel []byte, data []byte, packetSize int)
 
 		sequenceIdx = sequenceIdx + 1
 
-		if offset == len(data) {
-			return nil, errors.New("No data")
+		// The early length check doesn't account for the headers of
+		// continuation frames, so the next frame may not be read yet
+		if offset + 5 > len(data) {
+			return nil, ErrMoreData
 		}
 
 		// Unpack channel in this sequence and compare
@@ -308,21 +683,33 @@ func (l *Ledger) unwrapResponseAPDU(channel []byte, data []byte, packetSize int)
 		if (responseLength - len(result)) > packetSize - 3 - extraHeaderSize {
 			blockSize = packetSize - 3 - extraHeaderSize
 		}
+
+		if offset + blockSize > len(data) {
+			return nil, ErrMoreData
+		}
 		result = append(result, data[offset:offset+blockSize]...)
 		offset = offset + blockSize
 	}
 
 	// End of decoding; check for errors
+	if len(result) < 2 {
+		return nil, errors.New("Response too short for status word")
+	}
 	swOffset := len(result) - 2
 	
 	sw := (int(result[swOffset]) << 8) + int(result[swOffset + 1])
 	if err := checkFailure(sw); err != nil {
-		return nil, err
+		return nil, &StatusError{sw, err}
 	}
 
 	// Actual result strips off trailing status code
 	result = result[:swOffset]
 
+	// 0x61xx; success, but xx more bytes are waiting on the device
+	if (sw & 0xFF00) == 0x6100 {
+		return result, moreDataAvailable(sw & 0xFF)
+	}
+
 	return result, nil
 }
 
@@ -334,29 +721,31 @@ func checkFailure(code int) error {
 
 	if code != 0x9000 && ((code & 0xFF00) != 0x6100) {
 		switch code {
+		case 0x5515:
+			return ErrDeviceLocked
 		case 0x6484:
 			return errors.New("Are you using the correct targetId?")
 		case 0x6982:
 			return errors.New("Have you uninstalled the existing CA with resetCustomCA first?")
 		case 0x6985:
-			return errors.New("Operation denied by the user")
+			return ErrUserDenied
 		case 0x6a80:
-			return errors.New("Level is below safety watermark")
+			return ErrBelowWatermark
 		case 0x6a84:
 		case 0x6a85:
 			return errors.New("Not enough space?")
 		case 0x6a83:
 			return errors.New("Maybe this app requires a library to be installed first?")
 		case 0x6b00:
-			return errors.New("Incorrect parameters received P1/P2")
+			return ErrIncorrectParams
 		case 0x6c00:
-			return errors.New("Wrong length")
+			return ErrWrongLength
 		case 0x6c66:
 			return errors.New("Operation not allowed")
 		case 0x6d00:
-			return errors.New("Unsupported Instruction")
+			return ErrUnsupportedIns
 		case 0x6e00:
-			return errors.New("Unexpected state of device: verify that the right application is opened?")
+			return ErrWrongApp
 		case 0x6f00:
 			return errors.New("Internal technical problem")
 		case 0x917e:
@@ -364,6 +753,10 @@ func checkFailure(code int) error {
 		case 0x9405:
 			return errors.New("Parse error")
 		default:
+			// 0x6cxx; wrong length, where xx is the length the device expected
+			if (code & 0xFF00) == 0x6c00 {
+				return errors.Wrapf(ErrWrongLength, "expected length 0x%02x", code & 0xFF)
+			}
 			return fmt.Errorf("Unknown status 0x%02x", code)
 		}
 	}
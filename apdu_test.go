@@ -0,0 +1,822 @@
+package ledger
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testChannel = []byte{1, 1}
+
+func TestUnwrapMoreDataAvailable(t *testing.T) {
+
+	l := &Ledger{}
+
+	head := []byte{0xde, 0xad, 0xbe, 0xef}
+	tail := []byte{0xca, 0xfe}
+
+	// First response carries the head, and 0x6120 indicating more bytes
+	frame, err := l.wrapCommandAPDU(testChannel, append(head, 0x61, 0x20), 64)
+	if err != nil {
+		t.Fatalf("Cannot wrap response: %s\n", err)
+	}
+
+	resp, err := l.unwrapResponseAPDU(testChannel, frame, 64)
+
+	var remaining moreDataAvailable
+	if !errors.As(err, &remaining) {
+		t.Fatalf("Expecting moreDataAvailable; Got %v", err)
+	}
+
+	if remaining != 0x20 {
+		t.Errorf("Expecting 32 remaining bytes; Got %d", remaining)
+	}
+
+	if !bytes.Equal(resp, head) {
+		t.Errorf("Expecting %x; Got %x", head, resp)
+	}
+
+	// GET RESPONSE returns the tail and 0x9000
+	frame, err = l.wrapCommandAPDU(testChannel, append(tail, 0x90, 0x00), 64)
+	if err != nil {
+		t.Fatalf("Cannot wrap response: %s\n", err)
+	}
+
+	resp, err = l.unwrapResponseAPDU(testChannel, frame, 64)
+	if err != nil {
+		t.Fatalf("Cannot unwrap response: %s\n", err)
+	}
+
+	if !bytes.Equal(resp, tail) {
+		t.Errorf("Expecting %x; Got %x", tail, resp)
+	}
+}
+
+func TestGetResponseApdu(t *testing.T) {
+
+	b, err := getResponseApdu{0x20}.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Cannot marshal: %s\n", err)
+	}
+
+	expected := []byte{0x00, 0xC0, 0x00, 0x00, 0x20}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("Expecting %x; Got %x", expected, b)
+	}
+}
+
+func TestCheckFailureSentinels(t *testing.T) {
+
+	for code, sentinel := range map[int]error{
+		0x6985: ErrUserDenied,
+		0x6a80: ErrBelowWatermark,
+		0x6e00: ErrWrongApp,
+	} {
+		if err := checkFailure(code); !errors.Is(err, sentinel) {
+			t.Errorf("Expecting %v for 0x%04x; Got %v", sentinel, code, err)
+		}
+	}
+
+	if err := checkFailure(0x9000); err != nil {
+		t.Errorf("Expecting nil for 0x9000; Got %v", err)
+	}
+}
+
+func TestCheckFailureWrongLength(t *testing.T) {
+
+	err := checkFailure(0x6c20)
+	if !errors.Is(err, ErrWrongLength) {
+		t.Errorf("Expecting ErrWrongLength; Got %v", err)
+	}
+
+	if err == nil || !strings.Contains(err.Error(), "length 0x20") {
+		t.Errorf("Expecting expected length 0x20 in error; Got %v", err)
+	}
+}
+
+// Splits a wrapped response into the frames a device would return
+func testFrames(b *testing.B, size int) [][]byte {
+
+	l := &Ledger{}
+
+	payload := make([]byte, size)
+	payload = append(payload, 0x90, 0x00)
+
+	wrapped, err := l.wrapCommandAPDU(testChannel, payload, 64)
+	if err != nil {
+		b.Fatalf("Cannot wrap response: %s\n", err)
+	}
+
+	var frames [][]byte
+	for i := 0; i < len(wrapped); i += 64 {
+		frames = append(frames, wrapped[i:i+64])
+	}
+
+	return frames
+}
+
+// Stands in for Dev.Read(); called indirectly so that, as with the
+// real device, the buffer read into escapes to the heap
+var readFrame = func(dst, src []byte) int {
+	return copy(dst, src)
+}
+
+// Simulates Read() accumulating frames, then unwrapping
+func benchmarkReadFrames(b *testing.B, pooled bool) {
+
+	l := &Ledger{}
+	frames := testFrames(b, 2048)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+
+		var result []byte
+
+		for _, f := range frames {
+			if pooled {
+				frame := getFrame(64)
+				readFrame(*frame, f)
+				result = append(result, *frame...)
+				putFrame(frame)
+			} else {
+				r := make([]byte, 64)
+				readFrame(r, f)
+				result = append(result, r...)
+			}
+		}
+
+		if _, err := l.unwrapResponseAPDU(testChannel, result, 64); err != nil {
+			b.Fatalf("Cannot unwrap response: %s\n", err)
+		}
+	}
+}
+
+func BenchmarkReadFramesAlloc(b *testing.B) {
+	benchmarkReadFrames(b, false)
+}
+
+func BenchmarkReadFramesPooled(b *testing.B) {
+	benchmarkReadFrames(b, true)
+}
+
+var roundTripLengths = []int{0, 1, 55, 56, 57, 58, 64, 116, 117, 121, 255, 256, 4096}
+
+func TestWrapUnwrapRoundTrip(t *testing.T) {
+
+	l := &Ledger{}
+
+	for _, n := range roundTripLengths {
+
+		payload := make([]byte, n)
+		for i := range payload {
+			payload[i] = byte(i)
+		}
+
+		wrapped, err := l.wrapCommandAPDU(testChannel, append(payload, 0x90, 0x00), 64)
+		if err != nil {
+			t.Fatalf("Cannot wrap %d bytes: %s\n", n, err)
+		}
+
+		if len(wrapped) % 64 != 0 {
+			t.Errorf("Expecting whole frames for %d bytes; Got %d bytes", n, len(wrapped))
+		}
+
+		unwrapped, err := l.unwrapResponseAPDU(testChannel, wrapped, 64)
+		if err != nil {
+			t.Errorf("Cannot unwrap %d bytes: %s\n", n, err)
+			continue
+		}
+
+		if !bytes.Equal(unwrapped, payload) {
+			t.Errorf("Round trip of %d bytes; Got %d bytes", n, len(unwrapped))
+		}
+	}
+}
+
+// Read() unwraps after every frame; each partial read must ask for more data
+func TestUnwrapPartialFrames(t *testing.T) {
+
+	l := &Ledger{}
+
+	for _, n := range roundTripLengths {
+
+		wrapped, err := l.wrapCommandAPDU(testChannel, append(make([]byte, n), 0x90, 0x00), 64)
+		if err != nil {
+			t.Fatalf("Cannot wrap %d bytes: %s\n", n, err)
+		}
+
+		for end := 64; end < len(wrapped); end += 64 {
+			if _, err := l.unwrapResponseAPDU(testChannel, wrapped[:end], 64); !errors.Is(err, ErrMoreData) {
+				t.Errorf("Expecting ErrMoreData for %d of %d bytes; Got %v", end, len(wrapped), err)
+				break
+			}
+		}
+	}
+}
+
+func TestUnwrapShortResponse(t *testing.T) {
+
+	l := &Ledger{}
+
+	// Response too short to carry a status word
+	wrapped, err := l.wrapCommandAPDU(testChannel, []byte{0x90}, 64)
+	if err != nil {
+		t.Fatalf("Cannot wrap: %s\n", err)
+	}
+
+	if _, err := l.unwrapResponseAPDU(testChannel, wrapped, 64); err == nil {
+		t.Errorf("Expecting error for response without status word")
+	}
+}
+
+func TestWriteRequestTooLarge(t *testing.T) {
+
+	l := &Ledger{
+		Dev:            NewReplayer(&Fixtures{}),
+		MaxRequestSize: DefaultMaxRequestSize,
+	}
+
+	if _, err := l.Write(make(testApdu, DefaultMaxRequestSize+1), testChannel); !errors.Is(err, ErrRequestTooLarge) {
+		t.Errorf("Expecting ErrRequestTooLarge; Got %v", err)
+	}
+}
+
+func TestReadRetriesTransientStatus(t *testing.T) {
+
+	request := testApdu{0x80, 0x00, 0x00, 0x00, 0x00}
+
+	// Device is busy, then answers
+	fixtures := func() *Fixtures {
+		return &Fixtures{
+			Exchanges: map[string][]*Exchange{
+				"00": {
+					testExchange(t, request, []byte{0x6f, 0x00}),
+					testExchange(t, request, []byte{0xab, 0x90, 0x00}),
+				},
+			},
+		}
+	}
+
+	l := &Ledger{
+		Dev:               NewReplayer(fixtures()),
+		TransientStatuses: DefaultTransientStatuses,
+		TransientRetries:  1,
+	}
+
+	if _, err := l.Write(request, testChannel); err != nil {
+		t.Fatalf("Cannot write: %s\n", err)
+	}
+
+	resp, err := l.Read(testChannel)
+	if err != nil {
+		t.Fatalf("Expecting retry to succeed; Got %s\n", err)
+	}
+
+	if !bytes.Equal(resp, []byte{0xab}) {
+		t.Errorf("Expecting ab; Got %x", resp)
+	}
+
+	// Without retries, the status is returned
+	l = &Ledger{
+		Dev:               NewReplayer(fixtures()),
+		TransientStatuses: DefaultTransientStatuses,
+	}
+
+	if _, err := l.Write(request, testChannel); err != nil {
+		t.Fatalf("Cannot write: %s\n", err)
+	}
+
+	var statusErr *StatusError
+	if _, err := l.Read(testChannel); !errors.As(err, &statusErr) || statusErr.Status != 0x6f00 {
+		t.Errorf("Expecting status 0x6f00; Got %v", err)
+	}
+
+	// Nor within a multi-APDU exchange
+	l = &Ledger{
+		Dev:               NewReplayer(fixtures()),
+		TransientStatuses: DefaultTransientStatuses,
+		TransientRetries:  1,
+	}
+
+	l.BeginMultiPart()
+	defer l.EndMultiPart()
+
+	if _, err := l.Write(request, testChannel); err != nil {
+		t.Fatalf("Cannot write: %s\n", err)
+	}
+
+	if _, err := l.Read(testChannel); !errors.As(err, &statusErr) || statusErr.Status != 0x6f00 {
+		t.Errorf("Expecting status 0x6f00 within multi-part exchange; Got %v", err)
+	}
+}
+
+// Void operations, such as deauthorizing, answer with only a status word
+func TestUnwrapStatusOnly(t *testing.T) {
+
+	l := &Ledger{}
+
+	wrapped, err := l.wrapCommandAPDU(testChannel, []byte{0x90, 0x00}, 64)
+	if err != nil {
+		t.Fatalf("Cannot wrap: %s\n", err)
+	}
+
+	// Both a padded frame, and one trimmed to its content
+	for _, frame := range [][]byte{wrapped, wrapped[:5 + 2 + 2]} {
+
+		resp, err := l.unwrapResponseAPDU(testChannel, frame, 64)
+		if err != nil {
+			t.Errorf("Cannot unwrap %d byte frame: %s\n", len(frame), err)
+		}
+
+		if len(resp) != 0 {
+			t.Errorf("Expecting empty response; Got %x", resp)
+		}
+	}
+}
+
+func BenchmarkWrapUnwrap(b *testing.B) {
+
+	l := &Ledger{}
+	payload := append(make([]byte, 1024), 0x90, 0x00)
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+
+		wrapped, err := l.wrapCommandAPDU(testChannel, payload, 64)
+		if err != nil {
+			b.Fatalf("Cannot wrap: %s\n", err)
+		}
+
+		if _, err := l.unwrapResponseAPDU(testChannel, wrapped, 64); err != nil {
+			b.Fatalf("Cannot unwrap: %s\n", err)
+		}
+	}
+}
+
+// Returns each frame from the underlying device in pieces of at most chunk bytes
+type chunkedDevice struct {
+	*Replayer
+	chunk   int
+	pending []byte
+}
+
+func (d *chunkedDevice) ReadTimeout(b []byte, timeout int) (int, error) {
+
+	if len(d.pending) == 0 {
+
+		frame := make([]byte, hidPacketSize)
+
+		n, err := d.Replayer.Read(frame)
+		if n <= 0 {
+			return n, err
+		}
+		d.pending = frame[:n]
+	}
+
+	if len(b) > d.chunk {
+		b = b[:d.chunk]
+	}
+
+	n := copy(b, d.pending)
+	d.pending = d.pending[n:]
+
+	return n, nil
+}
+
+func TestReadPartialFrames(t *testing.T) {
+
+	request := testApdu{0x80, 0x00, 0x00, 0x00, 0x00}
+	payload := make([]byte, 150)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	f := &Fixtures{
+		Exchanges: map[string][]*Exchange{
+			"00": {testExchange(t, request, append(payload, 0x90, 0x00))},
+		},
+	}
+
+	l := &Ledger{Dev: &chunkedDevice{Replayer: NewReplayer(f), chunk: 20}}
+
+	if _, err := l.Write(request, testChannel); err != nil {
+		t.Fatalf("Cannot write: %s\n", err)
+	}
+
+	resp, err := l.Read(testChannel)
+	if err != nil {
+		t.Fatalf("Cannot read: %s\n", err)
+	}
+
+	if !bytes.Equal(resp, payload) {
+		t.Errorf("Expecting %x; Got %x", payload, resp)
+	}
+}
+
+func TestAbortRead(t *testing.T) {
+
+	request := testApdu{0x80, 0x04, 0x00, 0x00, 0x00}
+
+	// Device accepts the request, but never replies
+	f := &Fixtures{
+		Exchanges: map[string][]*Exchange{
+			"04": {testExchange(t, request, []byte{0x90, 0x00})},
+		},
+	}
+	f.Exchanges["04"][0].Response = nil
+
+	l := &Ledger{Dev: NewReplayer(f)}
+
+	if _, err := l.Write(request, testChannel); err != nil {
+		t.Fatalf("Cannot write: %s\n", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := l.Read(testChannel)
+		done <- err
+	}()
+
+	// Let Read() start waiting
+	time.Sleep(150 * time.Millisecond)
+	l.Abort()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrAborted) {
+			t.Errorf("Expecting ErrAborted; Got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Read was not aborted")
+	}
+}
+
+func TestAbortBlockingRead(t *testing.T) {
+
+	request := testApdu{0x80, 0x04, 0x00, 0x00, 0x00}
+
+	dev := newBlockingDevice(nil)
+	reopened := newBlockingDevice(nil)
+
+	l := &Ledger{Dev: dev}
+	l.open = func() (HIDDevice, error) { return reopened, nil }
+
+	// The handle is in blocking mode, as while waiting on the user to sign
+	dev.SetNonBlocking(false)
+
+	if _, err := l.Write(request, testChannel); err != nil {
+		t.Fatalf("Cannot write: %s\n", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := l.Read(testChannel)
+		done <- err
+	}()
+
+	// Let Read() start waiting
+	time.Sleep(150 * time.Millisecond)
+	l.Abort()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrAborted) {
+			t.Errorf("Expecting ErrAborted; Got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Read was not aborted")
+	}
+
+	// The handle left mid-exchange is replaced
+	select {
+	case <-dev.closed:
+	default:
+		t.Errorf("Expecting aborted handle to be closed")
+	}
+
+	if l.Dev != reopened {
+		t.Errorf("Expecting device to be reopened")
+	}
+}
+
+func TestAbortBeforeRead(t *testing.T) {
+
+	request := testApdu{0x80, 0x04, 0x00, 0x00, 0x00}
+	reply, _ := hex.DecodeString(testExchange(t, request, []byte{0x90, 0x00}).Response[0])
+
+	// An abort made between writing and reading is not lost
+	l := &Ledger{Dev: newBlockingDevice(nil)}
+
+	if _, err := l.Write(request, testChannel); err != nil {
+		t.Fatalf("Cannot write: %s\n", err)
+	}
+
+	l.Abort()
+
+	if _, err := l.Read(testChannel); !errors.Is(err, ErrAborted) {
+		t.Errorf("Expecting ErrAborted; Got %v", err)
+	}
+
+	// Nor does it carry over into the next exchange
+	l.Dev = newBlockingDevice(reply)
+
+	if _, err := l.Write(request, testChannel); err != nil {
+		t.Fatalf("Cannot write: %s\n", err)
+	}
+
+	if _, err := l.Read(testChannel); err != nil {
+		t.Errorf("Expecting reply; Got %v", err)
+	}
+
+	// Within a multi-APDU exchange, it refuses the APDUs which follow
+	l.BeginMultiPart()
+	defer l.EndMultiPart()
+
+	l.Abort()
+
+	if _, err := l.Write(request, testChannel); !errors.Is(err, ErrAborted) {
+		t.Errorf("Expecting ErrAborted; Got %v", err)
+	}
+}
+
+// Blocks in Read() until closed, as hidapi does in blocking mode, while
+// ReadTimeout() gives up after the timeout. Answers each write with reply,
+// or never if it is nil.
+type blockingDevice struct {
+	HIDDevice
+	reply  []byte
+	frames chan []byte
+	closed chan struct{}
+}
+
+func newBlockingDevice(reply []byte) *blockingDevice {
+	return &blockingDevice{
+		reply:  reply,
+		frames: make(chan []byte, 1),
+		closed: make(chan struct{}),
+	}
+}
+
+func (d *blockingDevice) Write(b []byte) (int, error) {
+
+	if d.reply != nil {
+		d.frames <- d.reply
+	}
+
+	return len(b), nil
+}
+
+func (d *blockingDevice) Read(b []byte) (int, error) {
+
+	select {
+	case frame := <-d.frames:
+		return copy(b, frame), nil
+	case <-d.closed:
+		return -1, errors.New("device closed")
+	}
+}
+
+func (d *blockingDevice) ReadTimeout(b []byte, timeout int) (int, error) {
+
+	select {
+	case frame := <-d.frames:
+		return copy(b, frame), nil
+	case <-d.closed:
+		return -1, errors.New("device closed")
+	case <-time.After(time.Duration(timeout) * time.Millisecond):
+		return 0, nil
+	}
+}
+
+func (d *blockingDevice) SetNonBlocking(nonblocking bool) (int, error) {
+	return 0, nil
+}
+
+func (d *blockingDevice) Close() error {
+	close(d.closed)
+	return nil
+}
+
+func TestResponseTooLarge(t *testing.T) {
+
+	dev := &streamingDevice{}
+	l := &Ledger{Dev: dev, MaxResponseSize: 512}
+
+	if _, err := l.Write(testApdu{0x80, 0x00, 0x00, 0x00, 0x00}, testChannel); err != nil {
+		t.Fatalf("Cannot write: %s\n", err)
+	}
+
+	if _, err := l.Read(testChannel); !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("Expecting ErrResponseTooLarge; Got %v", err)
+	}
+
+	// Reading stops once MaxResponseSize bytes are held
+	if max := l.MaxResponseSize / hidPacketSize; dev.reads != max {
+		t.Errorf("Expecting %d frames read; Got %d", max, dev.reads)
+	}
+}
+
+// Answers a request with a response claiming the maximum length, then sends
+// continuation frames for as long as it is read
+type streamingDevice struct {
+	HIDDevice
+	streaming bool
+	reads     int
+}
+
+func (d *streamingDevice) Write(b []byte) (int, error) {
+	d.streaming = true
+	return len(b), nil
+}
+
+func (d *streamingDevice) ReadTimeout(b []byte, timeout int) (int, error) {
+
+	if !d.streaming {
+		return 0, nil
+	}
+
+	for i := range b {
+		b[i] = 0
+	}
+
+	// Channel, tag, sequence; the first frame also carries the length
+	copy(b, []byte{0x01, 0x01, 0x05, byte(d.reads >> 8), byte(d.reads)})
+	if d.reads == 0 {
+		copy(b[5:], []byte{0xff, 0xff})
+	}
+
+	d.reads++
+
+	return len(b), nil
+}
+
+func TestDrainStaleFrames(t *testing.T) {
+
+	request := testApdu{0x80, 0x00, 0x00, 0x00, 0x00}
+
+	// A late reply to an earlier, abandoned request
+	stale, _ := hex.DecodeString(testExchange(t, request, []byte{0xde, 0xad, 0x90, 0x00}).Response[0])
+	reply, _ := hex.DecodeString(testExchange(t, request, []byte{0xca, 0xfe, 0x90, 0x00}).Response[0])
+
+	dev := &staleDevice{reply: reply}
+	for i := 0; i < 3; i++ {
+		dev.queue = append(dev.queue, stale)
+	}
+
+	l := &Ledger{Dev: dev}
+
+	if _, err := l.Write(request, testChannel); err != nil {
+		t.Fatalf("Cannot write: %s\n", err)
+	}
+
+	if dev.reads != 3 {
+		t.Errorf("Expecting 3 stale frames drained; Got %d", dev.reads)
+	}
+
+	resp, err := l.Read(testChannel)
+	if err != nil || !bytes.Equal(resp, []byte{0xca, 0xfe}) {
+		t.Errorf("Expecting cafe; Got %x (%v)", resp, err)
+	}
+
+	// A device which never stops sending is not drained forever
+	dev = &staleDevice{reply: reply}
+	for i := 0; i < maxDrainFrames+8; i++ {
+		dev.queue = append(dev.queue, stale)
+	}
+
+	l = &Ledger{Dev: dev}
+
+	if _, err := l.Write(request, testChannel); err != nil {
+		t.Fatalf("Cannot write: %s\n", err)
+	}
+
+	if dev.reads != maxDrainFrames {
+		t.Errorf("Expecting %d stale frames drained; Got %d", maxDrainFrames, dev.reads)
+	}
+}
+
+// Holds frames queued before the request is written, such as a late reply, and
+// queues reply after each write
+type staleDevice struct {
+	HIDDevice
+	queue [][]byte
+	reply []byte
+	reads int
+}
+
+func (d *staleDevice) Write(b []byte) (int, error) {
+	d.queue = append(d.queue, d.reply)
+	return len(b), nil
+}
+
+func (d *staleDevice) ReadTimeout(b []byte, timeout int) (int, error) {
+
+	if len(d.queue) == 0 {
+		return 0, nil
+	}
+
+	n := copy(b, d.queue[0])
+	d.queue = d.queue[1:]
+	d.reads++
+
+	return n, nil
+}
+
+func TestTransportError(t *testing.T) {
+
+	// A write which fails is reported as a transport error, not a status
+	l := &Ledger{Dev: writeFailDevice{}}
+
+	_, err := l.Write(testApdu{0x80, 0x00, 0x00, 0x00, 0x00}, testChannel)
+
+	var transportErr *TransportError
+	if !errors.As(err, &transportErr) || transportErr.Op != "write" {
+		t.Errorf("Expecting TransportError; Got %v", err)
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		t.Errorf("Expecting no StatusError; Got %v", err)
+	}
+}
+
+// Fails every write
+type writeFailDevice struct {
+	HIDDevice
+}
+
+func (writeFailDevice) Write(b []byte) (int, error) {
+	return -1, errors.New("device unplugged")
+}
+
+func (writeFailDevice) ReadTimeout(b []byte, timeout int) (int, error) {
+	return 0, nil
+}
+
+func TestReadContext(t *testing.T) {
+
+	l := &Ledger{Dev: silentDevice{}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150 * time.Millisecond)
+	defer cancel()
+
+	if _, err := l.ReadContext(ctx, testChannel); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expecting ErrTimeout; Got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := l.ReadContext(ctx, testChannel); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expecting context.Canceled; Got %v", err)
+	}
+}
+
+// Never answers
+type silentDevice struct {
+	HIDDevice
+}
+
+func (silentDevice) Read(b []byte) (int, error) {
+	return 0, nil
+}
+
+func (silentDevice) ReadTimeout(b []byte, timeout int) (int, error) {
+	return 0, nil
+}
+
+func TestGetResponseKeepsRequest(t *testing.T) {
+
+	request := testApdu{0x80, 0x00, 0x00, 0x00, 0x00}
+
+	f := &Fixtures{
+		Exchanges: map[string][]*Exchange{
+			"00": {testExchange(t, request, []byte{0xde, 0xad, 0x61, 0x02})},
+			"c0": {testExchange(t, []byte{0x00, 0xc0, 0x00, 0x00, 0x02}, []byte{0xca, 0xfe, 0x90, 0x00})},
+		},
+	}
+
+	l := &Ledger{Dev: NewReplayer(f)}
+
+	if _, err := l.Write(request, testChannel); err != nil {
+		t.Fatalf("Cannot write: %s\n", err)
+	}
+	written := l.lastRequest
+
+	resp, err := l.Read(testChannel)
+	if err != nil || !bytes.Equal(resp, []byte{0xde, 0xad, 0xca, 0xfe}) {
+		t.Fatalf("Expecting deadcafe; Got %x (%v)", resp, err)
+	}
+
+	// A retry would resend the request, not GET RESPONSE
+	if !bytes.Equal(l.lastRequest, written) {
+		t.Errorf("Expecting last request kept; Got %x", l.lastRequest)
+	}
+}
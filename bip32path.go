@@ -9,6 +9,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"strconv"
+	"strings"
 	"regexp"
 
 	"github.com/pkg/errors"
@@ -100,3 +101,72 @@ func DecodeBipPath(pathBytes []byte) (string, error) {
 	
 	return path, nil
 }
+
+// Names of registered coin types which may be seen in a derivation path
+// https://github.com/satoshilabs/slips/blob/master/slip-0044.md
+var coinNames = map[int]string{
+	0:    "Bitcoin",
+	1:    "Testnet",
+	60:   "Ethereum",
+	1729: "Tezos",
+}
+
+// Annotates each level of a bip path with its meaning, for confirming at a glance
+// that a path is the one expected.
+// Ex: /44'/1729'/0'/0' = purpose 44', coin Tezos 1729', account 0', index 0'
+func DescribeBipPath(path string) string {
+
+	sections := matchSections.FindAllStringSubmatch(path, -1)
+
+	// purpose / coin / account / [change /] index
+	names := []string{"purpose", "coin", "account", "change", "index"}
+	if len(sections) == 4 {
+		names = []string{"purpose", "coin", "account", "index"}
+	}
+
+	parts := make([]string, 0, len(sections))
+
+	for i, section := range sections {
+
+		name := fmt.Sprintf("level %d", i)
+		if i < len(names) {
+			name = names[i]
+		}
+
+		level := section[1] + section[2]
+
+		if i == 1 {
+			if val, err := strconv.Atoi(section[1]); err == nil && coinNames[val] != "" {
+				level = coinNames[val] + " " + level
+			}
+		}
+
+		parts = append(parts, name + " " + level)
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// Verifies that the coin type, the second level of path, is the hardened coinType,
+// ie: 1729' for Tezos. A key derived under another coin type is a common mistake,
+// and yields a valid but unexpected address. encodeBipPath() accepts any coin type,
+// so this check is opt-in.
+func CheckCoinType(path string, coinType int) error {
+
+	sections := matchSections.FindAllStringSubmatch(path, -1)
+	if len(sections) < 2 {
+		return errors.New("Path has no coin type")
+	}
+
+	expected := fmt.Sprintf("%d'", coinType)
+	if name := coinNames[coinType]; name != "" {
+		expected += " (" + name + ")"
+	}
+
+	val, err := strconv.Atoi(sections[1][1])
+	if err != nil || val != coinType || sections[1][2] == "" {
+		return errors.Errorf("Coin type %s%s is not the expected %s", sections[1][1], sections[1][2], expected)
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package ledger
+
+import (
+	"testing"
+)
+
+func TestDescribeBipPath(t *testing.T) {
+
+	for path, expected := range map[string]string{
+		"/44'/1729'/0'/0'":    "purpose 44', coin Tezos 1729', account 0', index 0'",
+		"/44'/1729'/1'/0'/3'": "purpose 44', coin Tezos 1729', account 1', change 0', index 3'",
+		"/44'/9999'/0'/0'":    "purpose 44', coin 9999', account 0', index 0'",
+	} {
+		if d := DescribeBipPath(path); d != expected {
+			t.Errorf("Expecting %s; Got %s", expected, d)
+		}
+	}
+}
+
+func TestCheckCoinType(t *testing.T) {
+
+	if err := CheckCoinType("/44'/1729'/0'/0'", 1729); err != nil {
+		t.Errorf("Expecting Tezos path to pass; Got %s", err)
+	}
+
+	err := CheckCoinType("/44'/60'/0'/0'", 1729)
+	if err == nil || err.Error() != "Coin type 60' is not the expected 1729' (Tezos)" {
+		t.Errorf("Expecting coin type error; Got %v", err)
+	}
+
+	// Unhardened
+	if err := CheckCoinType("/44'/1729/0'/0'", 1729); err == nil {
+		t.Errorf("Expecting error for unhardened coin type")
+	}
+}
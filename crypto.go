@@ -7,8 +7,6 @@ import (
 
 	"github.com/pkg/errors"
 
-	"github.com/btcsuite/btcutil/base58"
-	
 	"golang.org/x/crypto/blake2b"
 )
 
@@ -37,6 +35,18 @@ func B58cdecode(payload string, prefix []byte) []byte {
 	return b58c[len(prefix):]
 }
 
+// ValidateB58Check decodes a base58check encoded string and verifies
+// its 4-byte double-SHA256 checksum. The prefix is not inspected.
+// Returns nil if valid, otherwise error
+func ValidateB58Check(s string) error {
+
+	if _, err := decode(s); err != nil {
+		return errors.Wrap(err, "Invalid base58check string")
+	}
+
+	return nil
+}
+
 const alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
 
 func encode(dataBytes []byte) string {
@@ -66,7 +76,7 @@ func encode(dataBytes []byte) string {
 	}
 
 	// Performing base58 encoding
-	encoded := base58.Encode(dataBytes)
+	encoded := b58encode(dataBytes)
 
 	for i := 0; i < zeroCount; i++ {
 		encoded = "1" + encoded
@@ -90,15 +100,15 @@ func decode(encoded string) ([]byte, error) {
 		return []byte{}, err
 	}
 
+	// Restore the leading zeros before splitting off the checksum; when the data
+	// is all zeros, they are all that is left of it
+	dataBytes = append(make([]byte, zeroCount), dataBytes...)
+
 	if len(dataBytes) <= 4 {
 		return []byte{}, errors.New("invalid decode length")
 	}
 	data, checksum := dataBytes[:len(dataBytes)-4], dataBytes[len(dataBytes)-4:]
 
-	for i := 0; i < zeroCount; i++ {
-		data = append([]byte{0}, data...)
-	}
-
 	// Performing SHA256 twice to validate checksum
 //	sha256hash := sha256.New()
 //	sha256hash.Write(data)
@@ -117,6 +127,28 @@ func decode(encoded string) ([]byte, error) {
 	return data, nil
 }
 
+// Encodes data as base58, without the leading "1" for each leading zero byte;
+// the inverse of b58decode()
+func b58encode(data []byte) string {
+
+	decimalData := new(big.Int).SetBytes(data)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	var encoded []byte
+	for decimalData.Sign() > 0 {
+		decimalData.DivMod(decimalData, radix, mod)
+		encoded = append(encoded, alphabet[mod.Int64()])
+	}
+
+	// Digits were produced least significant first
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+
+	return string(encoded)
+}
+
 func b58decode(data string) ([]byte, error) {
 	decimalData := new(big.Int)
 	alphabetBytes := []byte(alphabet)
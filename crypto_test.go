@@ -0,0 +1,85 @@
+package ledger
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestB58cencode(t *testing.T) {
+
+	hash := make([]byte, 20)
+	for i := range hash {
+		hash[i] = byte(i + 1)
+	}
+
+	tests := []struct {
+		payload  []byte
+		prefix   Prefix
+		expected string
+	}{
+		// Burn address; the all zero tz1 public key hash
+		{make([]byte, 20), Prefix{6, 161, 159}, "tz1Ke2h7sDdakHJQh8WX4Z372du1KChsksyU"},
+
+		// Leading zero bytes each encode as exactly one leading "1"; before the
+		// in-package b58encode() they were doubled, as btcutil already added them
+		{hash, Prefix{0, 0}, "116L5yRNPTuciSgXGHqYwn9N6NeoGU45ux"},
+	}
+
+	for _, tt := range tests {
+
+		encoded := B58cencode(tt.payload, tt.prefix)
+		if encoded != tt.expected {
+			t.Errorf("Expecting %s; Got %s", tt.expected, encoded)
+		}
+
+		if err := ValidateB58Check(encoded); err != nil {
+			t.Errorf("Cannot validate %s: %s", encoded, err)
+		}
+
+		if decoded := B58cdecode(encoded, tt.prefix); !bytes.Equal(decoded, tt.payload) {
+			t.Errorf("Expecting %x; Got %x", tt.payload, decoded)
+		}
+	}
+}
+
+func TestB58RoundTrip(t *testing.T) {
+
+	for _, data := range [][]byte{
+		{0x00},
+		{0x00, 0x00},
+		{0x00, 0x07},
+		{0x00, 0x00, 0x01},
+		{0x01, 0x00},
+		{0xff, 0xff, 0xff, 0xff},
+		bytes.Repeat([]byte{0x5a}, 64),
+	} {
+
+		decoded, err := decode(encode(append([]byte(nil), data...)))
+		if err != nil || !bytes.Equal(decoded, data) {
+			t.Errorf("Expecting %x; Got %x (%v)", data, decoded, err)
+		}
+	}
+}
+
+func TestB58LeadingZeros(t *testing.T) {
+
+	// Pins one "1" per leading zero byte; encode() used to emit two
+	for _, tt := range []struct {
+		data     []byte
+		raw      string
+		expected string
+	}{
+		{[]byte{0x00}, "", "1Wh4bh"},
+		{[]byte{0x00, 0x3a}, "21", "17befNKF"},
+		{[]byte{0x00, 0x00, 0x01}, "2", "11BwW2qR"},
+	} {
+
+		if raw := b58encode(tt.data); raw != tt.raw {
+			t.Errorf("Expecting %q; Got %q", tt.raw, raw)
+		}
+
+		if encoded := encode(append([]byte(nil), tt.data...)); encoded != tt.expected {
+			t.Errorf("Expecting %s; Got %s", tt.expected, encoded)
+		}
+	}
+}
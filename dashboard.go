@@ -0,0 +1,203 @@
+package ledger
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Instruction class of the dashboard (BOLOS) commands
+	DashboardCLA uint8 = 0xe0
+
+	// Dashboard instruction returning the target id and firmware versions
+	dashboardGetVersion uint8 = 0x01
+
+	// Dashboard instruction returning one battery reading, selected by P2
+	dashboardGetBattery uint8 = 0x10
+	batteryPercentage   uint8 = 0x00
+)
+
+var (
+	ErrNoBattery          = errors.New("Device model has no battery")
+	ErrBatteryUnsupported = errors.New("Firmware does not report battery status")
+)
+
+// Channel used when talking to the dashboard
+var dashboardChannel = []byte{0x01, 0x01}
+
+// An APDU addressed to the dashboard rather than an app
+type dashboardApdu struct {
+	INS   uint8
+	P1    uint8
+	P2    uint8
+	CDATA []byte
+}
+
+func (a dashboardApdu) MarshalBinary() ([]byte, error) {
+
+	b := []byte{DashboardCLA, a.INS, a.P1, a.P2, byte(len(a.CDATA))}
+
+	return append(b, a.CDATA...), nil
+}
+
+// TargetInfo identifies the secure element of the device, as needed by tooling
+// installing apps, along with the firmware versions it is running
+type TargetInfo struct {
+	TargetID   uint32
+	SEVersion  string
+	Flags      []byte
+	MCUVersion string
+}
+
+// Returns the target id and firmware versions of the device. Only answered by the
+// dashboard; close any open app first.
+func (l *Ledger) GetTargetInfo() (TargetInfo, error) {
+
+	apdu := dashboardApdu{
+		dashboardGetVersion,
+		0x00,
+		0x00,
+		nil,
+	}
+
+	_, err := l.Write(apdu, dashboardChannel)
+	if err != nil {
+		return TargetInfo{}, errors.Wrap(err, "Unable to write target info request")
+	}
+
+	resp, err := l.Read(dashboardChannel)
+	if err != nil {
+		return TargetInfo{}, errors.Wrap(err, "Unable to read target info reply")
+	}
+
+	return parseTargetInfo(resp)
+}
+
+// Best-effort check of whether the device is unlocked. The device has no query for
+// this, so it is inferred from the reply to a harmless request: a locked device
+// answers ErrDeviceLocked (0x5515), or 0x6982 (security status not satisfied) on
+// older firmware, while any other reply, even a rejection by the open app, means
+// it is unlocked. A device which does not answer at all may be locked, asleep, or
+// gone, and is reported as an error.
+func (l *Ledger) IsUnlocked() (bool, error) {
+
+	apdu := dashboardApdu{
+		dashboardGetVersion,
+		0x00,
+		0x00,
+		nil,
+	}
+
+	_, err := l.Write(apdu, dashboardChannel)
+	if err != nil {
+		return false, errors.Wrap(err, "Unable to write unlock probe")
+	}
+
+	_, err = l.Read(dashboardChannel)
+
+	var statusErr *StatusError
+
+	switch {
+	case err == nil:
+		return true, nil
+	case !errors.As(err, &statusErr):
+		return false, errors.Wrap(err, "Unable to read unlock probe reply")
+	case statusErr.Status == 0x5515, statusErr.Status == 0x6982:
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Returns the charge of the battery as a percentage. Only the Nano X has a battery;
+// other models return ErrNoBattery without asking the device, and firmware which
+// cannot report it returns ErrBatteryUnsupported. Only answered by the dashboard;
+// close any open app first.
+func (l *Ledger) BatteryLevel() (int, error) {
+
+	if modelName(l.Device.ProductID) != "Nano X" {
+		return 0, ErrNoBattery
+	}
+
+	apdu := dashboardApdu{
+		dashboardGetBattery,
+		0x00,
+		batteryPercentage,
+		nil,
+	}
+
+	_, err := l.Write(apdu, dashboardChannel)
+	if err != nil {
+		return 0, errors.Wrap(err, "Unable to write battery request")
+	}
+
+	resp, err := l.Read(dashboardChannel)
+	if errors.Is(err, ErrUnsupportedIns) {
+		return 0, ErrBatteryUnsupported
+	} else if err != nil {
+		return 0, errors.Wrap(err, "Unable to read battery reply")
+	}
+
+	if len(resp) < 1 || resp[0] > 100 {
+		return 0, errors.Errorf("Invalid battery level %x", resp)
+	}
+
+	return int(resp[0]), nil
+}
+
+// Helper function to parse the reply to the dashboard's version instruction
+//   target id (4) | len (1) | se version | len (1) | flags | len (1) | mcu version
+// Older firmware stops after the se version.
+func parseTargetInfo(resp []byte) (TargetInfo, error) {
+
+	if len(resp) < 4 {
+		return TargetInfo{}, errors.New("Not enough data returned")
+	}
+
+	info := TargetInfo{
+		TargetID: binary.BigEndian.Uint32(resp[:4]),
+	}
+
+	rest := resp[4:]
+
+	// Each following field is length prefixed
+	field := func() ([]byte, error) {
+
+		if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+			return nil, errors.New("Truncated target info")
+		}
+
+		f := rest[1 : 1+int(rest[0])]
+		rest = rest[1+int(rest[0]):]
+
+		return f, nil
+	}
+
+	se, err := field()
+	if err != nil {
+		return TargetInfo{}, err
+	}
+	info.SEVersion = string(se)
+
+	if len(rest) == 0 {
+		return info, nil
+	}
+
+	if info.Flags, err = field(); err != nil {
+		return TargetInfo{}, err
+	}
+
+	mcu, err := field()
+	if err != nil {
+		return TargetInfo{}, err
+	}
+
+	// The mcu version is NUL terminated on some firmware
+	if n := len(mcu); n > 0 && mcu[n-1] == 0x00 {
+		mcu = mcu[:n-1]
+	}
+	info.MCUVersion = string(mcu)
+
+	return info, nil
+}
@@ -0,0 +1,107 @@
+package ledger
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestGetTargetInfo(t *testing.T) {
+
+	reply := []byte{0x33, 0x00, 0x00, 0x04}
+	reply = append(reply, 5)
+	reply = append(reply, "2.0.0"...)
+	reply = append(reply, 4, 0x00, 0x00, 0x00, 0x00)
+	reply = append(reply, 5)
+	reply = append(reply, "1.12\x00"...)
+
+	f := &Fixtures{
+		Exchanges: map[string][]*Exchange{
+			"01": {testExchange(t, []byte{DashboardCLA, 0x01, 0x00, 0x00, 0x00}, append(reply, 0x90, 0x00))},
+		},
+	}
+
+	l := &Ledger{Dev: NewReplayer(f)}
+
+	info, err := l.GetTargetInfo()
+	if err != nil {
+		t.Fatalf("Cannot get target info: %s\n", err)
+	}
+
+	if info.TargetID != 0x33000004 || info.SEVersion != "2.0.0" || info.MCUVersion != "1.12" ||
+		!bytes.Equal(info.Flags, []byte{0, 0, 0, 0}) {
+		t.Errorf("Unexpected target info %+v", info)
+	}
+
+	// Older firmware; target id and se version only
+	info, err = parseTargetInfo(reply[:10])
+	if err != nil || info.SEVersion != "2.0.0" || info.Flags != nil {
+		t.Errorf("Unexpected target info %+v (%v)", info, err)
+	}
+
+	if _, err := parseTargetInfo(reply[:8]); err == nil {
+		t.Errorf("Expecting error for truncated reply")
+	}
+}
+
+func TestIsUnlocked(t *testing.T) {
+
+	probe := []byte{DashboardCLA, 0x01, 0x00, 0x00, 0x00}
+
+	for status, expected := range map[uint16]bool{
+		0x5515: false,
+		0x6982: false,
+		0x6e00: true,
+		0x9000: true,
+	} {
+
+		f := &Fixtures{
+			Exchanges: map[string][]*Exchange{
+				"01": {testExchange(t, probe, []byte{byte(status >> 8), byte(status)})},
+			},
+		}
+
+		l := &Ledger{Dev: NewReplayer(f)}
+
+		unlocked, err := l.IsUnlocked()
+		if err != nil || unlocked != expected {
+			t.Errorf("Status 0x%04x: expecting %v; Got %v (%v)", status, expected, unlocked, err)
+		}
+	}
+}
+
+func TestBatteryLevel(t *testing.T) {
+
+	request := []byte{DashboardCLA, 0x10, 0x00, 0x00, 0x00}
+
+	reply := func(resp []byte) *Ledger {
+
+		f := &Fixtures{
+			Exchanges: map[string][]*Exchange{
+				"10": {testExchange(t, request, resp)},
+			},
+		}
+
+		l := &Ledger{Dev: NewReplayer(f)}
+		l.Device.ProductID = 0x4011 // Nano X
+
+		return l
+	}
+
+	level, err := reply([]byte{0x4b, 0x90, 0x00}).BatteryLevel()
+	if err != nil || level != 75 {
+		t.Errorf("Expecting 75; Got %d (%v)", level, err)
+	}
+
+	if _, err := reply([]byte{0x6d, 0x00}).BatteryLevel(); !errors.Is(err, ErrBatteryUnsupported) {
+		t.Errorf("Expecting ErrBatteryUnsupported; Got %v", err)
+	}
+
+	// Wired models are not asked
+	l := &Ledger{}
+	l.Device.ProductID = 0x1011 // Nano S
+
+	if _, err := l.BatteryLevel(); !errors.Is(err, ErrNoBattery) {
+		t.Errorf("Expecting ErrNoBattery; Got %v", err)
+	}
+}
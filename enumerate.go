@@ -0,0 +1,141 @@
+package ledger
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/bakingbacon/hid"
+)
+
+const (
+	// USB vendor id of all Ledger devices
+	LedgerVendorID uint16 = 0x2c97
+
+	// HID usage page of the interface carrying APDUs
+	apduUsagePage uint16 = 0xffa0
+)
+
+// Names of Ledger models by the model id in the high byte of the product id, as
+// reported by newer firmware
+var modelNames = map[uint16]string{
+	0x00: "Blue",
+	0x10: "Nano S",
+	0x40: "Nano X",
+	0x50: "Nano S Plus",
+	0x60: "Stax",
+	0x70: "Flex",
+}
+
+// Names of Ledger models by the product id reported by older firmware
+var legacyModelNames = map[uint16]string{
+	0x0000: "Blue",
+	0x0001: "Nano S",
+	0x0004: "Nano X",
+	0x0005: "Nano S Plus",
+}
+
+// LedgerDeviceInfo describes a connected Ledger by one of its HID interfaces, for
+// listing devices to choose from
+type LedgerDeviceInfo struct {
+	Model     string
+	Serial    string
+	Path      string
+	ProductID uint16
+	Interface int
+	UsagePage uint16
+
+	// Whether this interface carries APDUs, and so can be opened to talk to the
+	// open app; other interfaces, such as FIDO U2F, cannot
+	APDU bool
+}
+
+// Lists every connected Ledger, whatever the model or open app, for discovery; ie: a
+// setup wizard letting the operator pick a device. Each device is listed once, by its
+// APDU interface where it has one, sorted by serial then path so that the order is
+// stable between calls. Open a listed device by passing its serial to WithSerial(),
+// or its path to LEDGER_HID_PATH.
+func EnumerateLedgers() []LedgerDeviceInfo {
+	return ledgerDevices(hid.Enumerate(LedgerVendorID, 0))
+}
+
+// Helper function to describe the enumerated interfaces, one per physical device
+func ledgerDevices(infos []hid.DeviceInfo) []LedgerDeviceInfo {
+
+	devices := make([]LedgerDeviceInfo, 0, len(infos))
+	seen := make(map[string]int)
+
+	for _, info := range infos {
+
+		if info.VendorID != LedgerVendorID {
+			continue
+		}
+
+		device := LedgerDeviceInfo{
+			Model:     modelName(info.ProductID),
+			Serial:    info.Serial,
+			Path:      info.Path,
+			ProductID: info.ProductID,
+			Interface: info.Interface,
+			UsagePage: info.UsagePage,
+
+			// Some platforms do not report usage pages; interface 0 is APDU
+			APDU: info.UsagePage == apduUsagePage || (info.UsagePage == 0 && info.Interface == 0),
+		}
+
+		// Another interface of a device already listed; keep the one carrying
+		// APDUs, or else the lowest numbered
+		key := physicalDeviceKey(info)
+		if i, ok := seen[key]; ok {
+
+			prev := devices[i]
+			if (device.APDU && !prev.APDU) || (device.APDU == prev.APDU && device.Interface < prev.Interface) {
+				devices[i] = device
+			}
+
+			continue
+		}
+
+		seen[key] = len(devices)
+		devices = append(devices, device)
+	}
+
+	sort.SliceStable(devices, func(i, j int) bool {
+		if devices[i].Serial != devices[j].Serial {
+			return devices[i].Serial < devices[j].Serial
+		}
+		return devices[i].Path < devices[j].Path
+	})
+
+	return devices
+}
+
+// Helper function to identify the physical device an interface belongs to; by serial,
+// or if the platform does not report one, by the path without its interface suffix
+//   ie: 0001:0004:00 and 0001:0004:01 are interfaces of device 0001:0004
+func physicalDeviceKey(info hid.DeviceInfo) string {
+
+	if info.Serial != "" {
+		return "serial:" + info.Serial
+	}
+
+	path := info.Path
+	if i := strings.LastIndex(path, ":"); i > 0 {
+		path = path[:i]
+	}
+
+	return "path:" + path
+}
+
+// Helper function to name the model of a device from its product id
+func modelName(productId uint16) string {
+
+	if name, ok := legacyModelNames[productId]; ok {
+		return name
+	}
+
+	if name, ok := modelNames[productId >> 8]; ok {
+		return name
+	}
+
+	return "Unknown"
+}
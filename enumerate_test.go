@@ -0,0 +1,40 @@
+package ledger
+
+import (
+	"testing"
+
+	"github.com/bakingbacon/hid"
+)
+
+func TestLedgerDevices(t *testing.T) {
+
+	devices := ledgerDevices([]hid.DeviceInfo{
+		{Path: "1-2:1.0", VendorID: LedgerVendorID, ProductID: 0x0001, Serial: "0002", Interface: 0},
+		{Path: "1-1:1.1", VendorID: LedgerVendorID, ProductID: 0x4011, Serial: "0001", Interface: 1, UsagePage: 0xf1d0},
+		{Path: "1-1:1.0", VendorID: LedgerVendorID, ProductID: 0x4011, Serial: "0001", Interface: 0, UsagePage: 0xffa0},
+		{Path: "1-3:1.0", VendorID: 0x1234, ProductID: 0x0001},
+
+		// No serial reported; interfaces of one device share a path prefix
+		{Path: "0001:0004:01", VendorID: LedgerVendorID, ProductID: 0x1011, Interface: 1, UsagePage: 0xf1d0},
+		{Path: "0001:0004:00", VendorID: LedgerVendorID, ProductID: 0x1011, Interface: 0, UsagePage: 0xffa0},
+	})
+
+	if len(devices) != 3 {
+		t.Fatalf("Expecting 3 Ledgers; Got %d: %+v", len(devices), devices)
+	}
+
+	expected := []struct {
+		model string
+		path  string
+	}{
+		{"Nano S", "0001:0004:00"},
+		{"Nano X", "1-1:1.0"},
+		{"Nano S", "1-2:1.0"},
+	}
+
+	for i, e := range expected {
+		if devices[i].Model != e.model || devices[i].Path != e.path || !devices[i].APDU {
+			t.Errorf("Device %d: expecting %s APDU interface %s; Got %+v", i, e.model, e.path, devices[i])
+		}
+	}
+}
@@ -0,0 +1,37 @@
+// +build gofuzz
+
+package ledger
+
+import (
+	"bytes"
+)
+
+// Fuzz target for go-fuzz, round tripping base58check encoding. The first byte
+// of data selects the length of the prefix taken from the rest.
+//   go-fuzz-build && go-fuzz
+func Fuzz(data []byte) int {
+
+	if len(data) < 2 {
+		return -1
+	}
+
+	prefixLen := int(data[0]) % 6
+	if len(data) < 1 + prefixLen + 1 {
+		return -1
+	}
+
+	prefix := Prefix(data[1 : 1+prefixLen])
+	payload := data[1+prefixLen:]
+
+	encoded := B58cencode(payload, prefix)
+
+	if err := ValidateB58Check(encoded); err != nil {
+		panic(err)
+	}
+
+	if decoded := B58cdecode(encoded, prefix); !bytes.Equal(decoded, payload) {
+		panic("base58check round trip mismatch")
+	}
+
+	return 1
+}
@@ -4,7 +4,6 @@ go 1.15
 
 require (
 	github.com/bakingbacon/hid v1.0.1
-	github.com/btcsuite/btcutil v1.0.2
 	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.8.1
 	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
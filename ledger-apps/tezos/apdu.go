@@ -23,6 +23,26 @@ const (
 	SignBytesWithHash uint8 = 0x0f // Sign a message with the ledger's key (with hash)
 )
 
+// Maximum length of CDATA; its length is encoded in a single byte
+const maxCDataLen = 255
+
+// Message sequence values of P1 when data spans several APDUs
+const (
+	P1First uint8 = 0x00 // Bip path, or the first of several
+	P1Other uint8 = 0x01 // Neither first nor last
+	P1Last  uint8 = 0x81 // Last data frame
+)
+
+// Derivation type (curve) of a key, as used in P2 of an APDU
+type Curve uint8
+
+const (
+	ED25519       Curve = 0x00 // tz1
+	SECP256K1     Curve = 0x01 // tz2
+	SECP256R1     Curve = 0x02 // tz3
+	BIP32_ED25519 Curve = 0x03 // tz1
+)
+
 // This struct represents the data to be encoded and sent to the device.
 // The following 2 components of the APDU are either static, or calculated at run-time
 //	CLA   uint8    // Instruction class (always 0x80)
@@ -30,7 +50,7 @@ const (
 // TzApdu implements the ledger.Apdu interface
 type TzApdu struct {
 	INS   uint8    // Instruction code (0x00-0x0f)
-	P1    uint8    // Message sequence (0x00 = first, 0x81 = last, 0x01 = other)
+	P1    uint8    // Message sequence (P1First, P1Other, P1Last)
 	P2    uint8    // Derivation type (0=ED25519, 1=SECP256K1, 2=SECP256R1, 3=BIPS32_ED25519)
 	CDATA []uint8  // Variable length data depending on INS
 }
@@ -52,3 +72,21 @@ func (a TzApdu) MarshalBinary() ([]byte, error) {
 
 	return bbytes, nil
 }
+
+// An APDU with an arbitrary instruction class, used by SendAPDU()
+type rawApdu struct {
+	CLA uint8
+	TzApdu
+}
+
+// Encodes the APDU as TzApdu does, substituting the instruction class
+func (a rawApdu) MarshalBinary() ([]byte, error) {
+
+	bbytes, err := a.TzApdu.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	bbytes[0] = a.CLA
+
+	return bbytes, nil
+}
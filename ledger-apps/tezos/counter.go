@@ -0,0 +1,51 @@
+package tezos
+
+import (
+	"sync"
+)
+
+// CounterManager hands out monotonically increasing operation counters for the
+// operations of a batch. It performs no RPC; the caller is responsible for seeding
+// it with the next counter of the source account (ie: the account's current
+// counter + 1). A CounterManager is safe for concurrent use.
+type CounterManager struct {
+	mu   sync.Mutex
+	next int64
+}
+
+// Returns a manager whose first call to Next() returns start
+func NewCounterManager(start int64) *CounterManager {
+	return &CounterManager{
+		next: start,
+	}
+}
+
+// Returns the counter to use for the next operation, and advances
+func (c *CounterManager) Next() int64 {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := c.next
+	c.next++
+
+	return n
+}
+
+// Returns the counter the next call to Next() will return, without advancing
+func (c *CounterManager) Peek() int64 {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.next
+}
+
+// Restarts the sequence at start, such as after a batch was rejected
+func (c *CounterManager) Reset(start int64) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.next = start
+}
@@ -0,0 +1,268 @@
+package tezos
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	goledger "github.com/bakingbacon/goledger"
+)
+
+// OperationKind is the tag byte which begins the forged contents of an operation
+type OperationKind uint8
+
+const (
+	// Consensus operations
+	OpEndorsement           OperationKind = 0x00 // Emmy endorsement
+	OpSeedNonceRevelation   OperationKind = 0x01
+	OpPreendorsement        OperationKind = 0x14 // 20
+	OpTenderbakeEndorsement OperationKind = 0x15 // 21
+
+	// Manager operations
+	OpReveal      OperationKind = 0x6b // 107
+	OpTransaction OperationKind = 0x6c // 108
+	OpOrigination OperationKind = 0x6d // 109
+	OpDelegation  OperationKind = 0x6e // 110
+)
+
+var (
+	ErrNotImplicit = errors.New("Address is not an implicit account (tz1/tz2/tz3)")
+	ErrNegative    = errors.New("Value cannot be negative")
+)
+
+// Forges the contents of a delegation operation, without branch. An empty delegate
+// forges a delegation withdrawal.
+// Returns hex of forged contents, or error
+func ForgeDelegation(source, delegate string, fee, counter, gas, storage int64) (string, error) {
+
+	forged, err := forgeManagerHeader(OpDelegation, source, fee, counter, gas, storage)
+	if err != nil {
+		return "", err
+	}
+
+	// Delegate is optional; 0x00 = none, 0xff = present
+	if delegate == "" {
+		forged = append(forged, 0x00)
+	} else {
+
+		delegateBytes, err := forgeImplicitAddress(delegate)
+		if err != nil {
+			return "", errors.Wrap(err, "Invalid delegate")
+		}
+
+		forged = append(forged, 0xff)
+		forged = append(forged, delegateBytes...)
+	}
+
+	return hex.EncodeToString(forged), nil
+}
+
+// Forges the contents of a transaction of amount mutez, without parameters or branch.
+// The destination may be an implicit account or an originated contract (KT1).
+// Returns hex of forged contents, or error
+func ForgeTransaction(source, destination string, amount, fee, counter, gas, storage int64) (string, error) {
+
+	forged, err := forgeManagerHeader(OpTransaction, source, fee, counter, gas, storage)
+	if err != nil {
+		return "", err
+	}
+
+	z, err := forgeZarith(amount)
+	if err != nil {
+		return "", errors.Wrap(err, "Invalid amount")
+	}
+	forged = append(forged, z...)
+
+	destinationBytes, err := EncodeContract(destination)
+	if err != nil {
+		return "", errors.Wrap(err, "Invalid destination")
+	}
+	forged = append(forged, destinationBytes...)
+
+	// No parameters
+	forged = append(forged, 0x00)
+
+	return hex.EncodeToString(forged), nil
+}
+
+// Forges the contents of a seed-nonce-revelation, revealing the nonce committed to
+// by the block baked at level, without branch
+//   kind (1) | level (4) | nonce (32)
+// Returns hex of forged contents, or error
+func ForgeNonceRevelation(level int32, nonce []byte) (string, error) {
+
+	if level < 0 {
+		return "", errors.Wrap(ErrNegative, "Invalid level")
+	}
+
+	if len(nonce) != 32 {
+		return "", errors.Wrapf(ErrInvalidNonce, "got %d bytes", len(nonce))
+	}
+
+	forged := []byte{byte(OpSeedNonceRevelation)}
+
+	levelBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(levelBytes, uint32(level))
+
+	forged = append(forged, levelBytes...)
+	forged = append(forged, nonce...)
+
+	return hex.EncodeToString(forged), nil
+}
+
+// Helper function to forge the fields common to manager operations
+//   kind (1) | source (21) | fee | counter | gas_limit | storage_limit
+func forgeManagerHeader(kind OperationKind, source string, fee, counter, gas, storage int64) ([]byte, error) {
+
+	forged := []byte{byte(kind)}
+
+	sourceBytes, err := forgeImplicitAddress(source)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid source")
+	}
+	forged = append(forged, sourceBytes...)
+
+	// fee, counter, gas_limit, storage_limit are all zarith encoded
+	for _, v := range []int64{fee, counter, gas, storage} {
+
+		z, err := forgeZarith(v)
+		if err != nil {
+			return nil, err
+		}
+		forged = append(forged, z...)
+	}
+
+	return forged, nil
+}
+
+// Forges, then signs, a delegation from source to delegate using the currently set bip path.
+// Use SetBipPath() before calling this function.
+// Returns signed operation, or error
+func (t *TezosLedger) DelegateTo(source, delegate string, fee, counter, gas, storage int64, branch string) (SignOperationOutput, error) {
+
+	contents, err := ForgeDelegation(source, delegate, fee, counter, gas, storage)
+	if err != nil {
+		return SignOperationOutput{}, errors.Wrap(err, "Unable to forge delegation")
+	}
+
+	return t.SignWithBranch(branch, contents)
+}
+
+// Forges, then signs, the revelation of the nonce committed to at level, using the
+// currently set bip path. Use SetBipPath() before calling this function.
+// Returns signed operation, or error
+func (t *TezosLedger) RevealNonce(level int32, nonce []byte, branch, chainID string) (SignOperationOutput, error) {
+
+	contents, err := ForgeNonceRevelation(level, nonce)
+	if err != nil {
+		return SignOperationOutput{}, errors.Wrap(err, "Unable to forge nonce revelation")
+	}
+
+	branchBytes, err := forgeBranch(branch)
+	if err != nil {
+		return SignOperationOutput{}, err
+	}
+
+	return t.SignNonce(hex.EncodeToString(branchBytes) + contents, chainID)
+}
+
+// Helper function to decode a block hash (B...) into the 32 bytes of an operation branch
+func forgeBranch(branch string) ([]byte, error) {
+
+	if !strings.HasPrefix(branch, "B") {
+		return nil, errors.New("Invalid branch")
+	}
+
+	if err := goledger.ValidateB58Check(branch); err != nil {
+		return nil, errors.Wrap(err, "Invalid branch")
+	}
+
+	branchBytes := goledger.B58cdecode(branch, branchprefix)
+	if len(branchBytes) != 32 {
+		return nil, errors.Errorf("Invalid branch length %d", len(branchBytes))
+	}
+
+	return branchBytes, nil
+}
+
+// Encodes an address as the 22 byte contract id used in forged operations, ie: the
+// destination of a transaction. The inverse of unforging a contract id.
+//   tz1/tz2/tz3: 0x00 | curve tag (1) | public key hash (20)
+//   KT1:         0x01 | contract hash (20) | 0x00 padding
+// Returns contract id bytes, or error
+func EncodeContract(address string) ([]byte, error) {
+
+	if !strings.HasPrefix(address, "KT1") {
+
+		implicit, err := forgeImplicitAddress(address)
+		if err != nil {
+			return nil, err
+		}
+
+		return append([]byte{0x00}, implicit...), nil
+	}
+
+	if err := goledger.ValidateB58Check(address); err != nil {
+		return nil, err
+	}
+
+	hash := goledger.B58cdecode(address, ktprefix)
+	if len(hash) != 20 {
+		return nil, errors.Errorf("Invalid contract length %d", len(hash))
+	}
+
+	contract := append([]byte{0x01}, hash...)
+
+	return append(contract, 0x00), nil
+}
+
+// Helper function to forge an implicit account address (tz1/tz2/tz3)
+// into its 21 byte tag + public key hash representation
+func forgeImplicitAddress(address string) ([]byte, error) {
+
+	var tag byte
+	var addrPrefix goledger.Prefix
+
+	switch {
+	case strings.HasPrefix(address, "tz1"):
+		tag, addrPrefix = 0x00, tz1prefix
+	case strings.HasPrefix(address, "tz2"):
+		tag, addrPrefix = 0x01, tz2prefix
+	case strings.HasPrefix(address, "tz3"):
+		tag, addrPrefix = 0x02, tz3prefix
+	default:
+		return nil, ErrNotImplicit
+	}
+
+	if err := goledger.ValidateB58Check(address); err != nil {
+		return nil, err
+	}
+
+	pkh := goledger.B58cdecode(address, addrPrefix)
+	if len(pkh) != 20 {
+		return nil, errors.Errorf("Invalid address length %d", len(pkh))
+	}
+
+	return append([]byte{tag}, pkh...), nil
+}
+
+// Helper function to encode a non-negative integer as zarith; 7 bits per byte,
+// least significant group first, high bit set on all but the last byte
+func forgeZarith(v int64) ([]byte, error) {
+
+	if v < 0 {
+		return nil, ErrNegative
+	}
+
+	var z []byte
+
+	for v >= 0x80 {
+		z = append(z, byte(v&0x7f)|0x80)
+		v >>= 7
+	}
+	z = append(z, byte(v))
+
+	return z, nil
+}
@@ -3,7 +3,7 @@ module github.com/bakingbacon/goledger/ledger-apps/tezos
 go 1.15
 
 require (
-	github.com/Messer4/base58check v0.0.0-20180328134002-7531a92ae9ba
-	github.com/bakingbacon/goledger v1.1.0
+	github.com/bakingbacon/goledger v1.2.0
 	github.com/pkg/errors v0.9.1
+	github.com/sirupsen/logrus v1.8.1
 )
@@ -0,0 +1,189 @@
+package tezos
+
+import (
+	"context"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	ledger "github.com/bakingbacon/goledger"
+)
+
+// Default number of times SignBlockResilient() reconnects and retries
+const DefaultSignRetries = 1
+
+// LazyLedger defers connecting to the device until it is first used, so that a
+// service may be started before the ledger is plugged in and unlocked. Once
+// connected, the handle is cached. If an operation fails because the device has
+// gone away, the handle is dropped and the next operation reconnects. The bip path
+// and curve set on a connection are re-applied to the next, so a path set once at
+// startup survives reconnecting.
+//
+// A LazyLedger is safe for concurrent use; operations are serialized.
+type LazyLedger struct {
+	// How long to wait for the device to appear when connecting
+	Timeout time.Duration
+
+	// Optional hook run after each (re)connection, such as to set the bip path,
+	// Curve, or Guard. If it returns an error, the connection is closed.
+	Setup func(*TezosLedger) error
+
+	// Number of times SignBlockResilient() reconnects and retries after a
+	// connection failure; 0 disables retrying
+	Retries int
+
+	opts []Option
+
+	// Bip path and curve of the last connection, re-applied when reconnecting
+	path  string
+	curve Curve
+
+	mu sync.Mutex
+	l  *TezosLedger
+}
+
+// Returns a LazyLedger which will wait up to timeout for the device to appear
+// whenever it needs to connect. Options are passed to GetWithTimeout().
+func NewLazyLedger(timeout time.Duration, opts ...Option) *LazyLedger {
+	return &LazyLedger{
+		Timeout: timeout,
+		Retries: DefaultSignRetries,
+		opts:    opts,
+	}
+}
+
+// Runs fn with the connected device, connecting first if necessary. If fn fails
+// and the device is no longer attached, the connection is dropped so that the
+// next call reconnects.
+// Returns the error from connecting, or from fn
+func (z *LazyLedger) Do(fn func(*TezosLedger) error) error {
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if err := z.connect(); err != nil {
+		return err
+	}
+
+	err := fn(z.l)
+	if err != nil && !z.l.Attached() {
+		z.drop()
+	}
+
+	return err
+}
+
+// Signs a block as TezosLedger.SignBlock() does, but if signing fails because of a
+// USB level failure (ledger.TransportError), such as a transient glitch, reconnects
+// and retries up to Retries times. Failures reported by the device, such as
+// ErrUserDenied or ErrBelowWatermark, and refusals by a client-side Guard, are
+// never retried. A Guard which outlives the connection has already recorded the
+// level, and so refuses the retry; seed a new guard in Setup instead.
+// Returns signed block, or error
+func (z *LazyLedger) SignBlockResilient(blockBytes, chainID string) (SignOperationOutput, error) {
+
+	// Malformed input fails the same way on every attempt
+	if _, err := hex.DecodeString(blockBytes); err != nil {
+		return SignOperationOutput{}, errors.Wrap(err, "Invalid block hex")
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	var signed SignOperationOutput
+	var err error
+
+	for attempt := 0; attempt <= z.Retries; attempt++ {
+
+		if err = z.connect(); err != nil {
+			continue
+		}
+
+		signed, err = z.l.SignBlock(blockBytes, chainID)
+		if err == nil || !isConnectionError(err) {
+			return signed, err
+		}
+
+		// Start over with a new handle
+		z.drop()
+	}
+
+	return SignOperationOutput{}, err
+}
+
+// Helper function to tell whether err is a failure to talk to the device, rather
+// than a reply from it, or a refusal to sign
+func isConnectionError(err error) bool {
+
+	var transportErr *ledger.TransportError
+
+	return errors.As(err, &transportErr)
+}
+
+// Closes the device, if connected. A later call to Do() reconnects.
+func (z *LazyLedger) Close() error {
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if z.l == nil {
+		return nil
+	}
+
+	z.remember()
+	err := z.l.Close()
+	z.l = nil
+
+	return err
+}
+
+// Helper function to connect, and run Setup, if not already connected
+func (z *LazyLedger) connect() error {
+
+	if z.l != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), z.Timeout)
+	defer cancel()
+
+	l, err := GetWithTimeout(ctx, z.opts...)
+	if err != nil {
+		return err
+	}
+
+	if z.path != "" {
+		if err := l.SetBipPathWithCurve(z.path, z.curve); err != nil {
+			l.Close()
+			return err
+		}
+	}
+
+	if z.Setup != nil {
+		if err := z.Setup(l); err != nil {
+			l.Close()
+			return err
+		}
+	}
+
+	z.l = l
+
+	return nil
+}
+
+// Helper function to discard a connection to a device which has gone away
+func (z *LazyLedger) drop() {
+	z.remember()
+	z.l.Close()
+	z.l = nil
+}
+
+// Helper function to keep the bip path and curve of the connection, which Close()
+// clears, for the next connection
+func (z *LazyLedger) remember() {
+	if z.l.BipPathString != "" {
+		z.path, z.curve = z.l.BipPathString, z.l.Curve
+	}
+}
@@ -5,9 +5,15 @@
 package tezos
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
-	_ "encoding/hex"
+	"encoding/hex"
 	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 
@@ -29,6 +35,8 @@ const (
 	LEDGER_IFACENUM  uint16 = 0
 
 	MAINNET_CHAINID  uint32 = 0x7A06A770 // Tezos mainnet NetXdQprcVkpaWU
+
+	TEZOS_COIN_TYPE = 1729 // SLIP-0044 coin type of Tezos
 )
 
 var (
@@ -37,6 +45,16 @@ var (
 	ErrLengthZero     = errors.New("Returned no data")
 	ErrLengthMismatch = errors.New("Returned data length mismatch")
 	ErrDecodeLength   = errors.New("Unable to decode length")
+	ErrNoResponse     = errors.New("Device did not respond")
+
+	ErrTezosAppNotOpen = errors.New("Tezos app is not open; open the Tezos Wallet or Baking app on the device")
+	ErrAddressMismatch = errors.New("Device key does not match expected address")
+	ErrNotAuthorized   = errors.New("No key is authorized for baking")
+	ErrConfirmTimeout  = errors.New("User did not confirm on the device in time")
+	ErrNotBakingApp    = errors.New("Only the Tezos Baking app has watermarks to reset")
+	ErrResetRound      = errors.New("The app resets watermarks to round 0; a later round cannot be set")
+
+	matchCommitHash = regexp.MustCompile(`^[0-9a-f]+(-dirty)?$`)
 )
 
 // TezosLedger is just a localized embedded struct of the parent
@@ -44,31 +62,228 @@ var (
 // along with implementing functions specific to the Tezos ledger app
 type TezosLedger struct {
 	*ledger.Ledger
+
+	// Derivation type (curve) of keys used by GetPublicKey(),
+	// GetPublicKeyWithPrompt(), SetupBaking(), AuthorizeBaking(), and
+	// signing. Defaults to ED25519. See SetBipPathWithCurve().
+	Curve Curve
+
+	// Consensus algorithm of the protocol being baked; selects the block and
+	// endorsement watermarks. Defaults to Emmy, and is not detected when signing:
+	// set it, or call DetectEra() once after connecting.
+	Era ConsensusEra
+
+	// Optional client-side double-signing guard consulted by SignBlock(),
+	// SignEndorsement(), and SignPreendorsement(); nil disables it
+	Guard *WatermarkGuard
+
+	// When set, SignBlock() first confirms chainID is the main chain the device
+	// is set up to bake on, at the cost of an extra exchange per block
+	CheckChainID bool
+
+	// When set, SetBipPath() refuses paths whose coin type is not 1729', as keys
+	// derived under another coin type are valid, but not the ones expected
+	StrictCoinType bool
+
+	// Optional hook given the exact bytes about to be sent to the device for
+	// signing, including the watermark and chain id, as hex. Returning an error
+	// aborts signing. For logging, or enforcing a policy on what is signed.
+	PreSign func(opHex string) error
+
+	// When set, SignBytes() first attempts to send the bip path and data in
+	// a single APDU if they fit, falling back to the two-phase exchange if
+	// the open app rejects the single-phase form
+	SinglePhaseSigning bool
+
+	// Set once the open app has rejected a single-phase signing request
+	singlePhaseUnsupported bool
+
+	// Held for the lifetime of a SignSession
+	session sync.Mutex
+}
+
+// USB identifiers used to locate the device
+type deviceIds struct {
+	vendor    uint16
+	product   uint16
+	iface     uint16
+	usagePage uint16
+
+	// Not identifiers, but passed along with them
+	openAttempts int
+	serial       string
+	matcher      ledger.DeviceMatcher
+}
+
+// Option overrides one of the USB identifiers used by Get() to locate the device
+type Option func(*deviceIds)
+
+// Overrides the USB vendor id (default LEDGER_VENDOR)
+func WithVendorID(vendor uint16) Option {
+	return func(d *deviceIds) { d.vendor = vendor }
+}
+
+// Overrides the USB product id (default LEDGER_PRODUCTID)
+func WithProductID(product uint16) Option {
+	return func(d *deviceIds) { d.product = product }
+}
+
+// Overrides the USB interface number (default LEDGER_IFACENUM)
+func WithInterface(iface uint16) Option {
+	return func(d *deviceIds) { d.iface = iface }
+}
+
+// Overrides the HID usage page (default LEDGER_USAGEPAGE)
+func WithUsagePage(usagePage uint16) Option {
+	return func(d *deviceIds) { d.usagePage = usagePage }
+}
+
+// Overrides the number of attempts made to open the device (default ledger.DefaultOpenAttempts)
+func WithOpenAttempts(attempts int) Option {
+	return func(d *deviceIds) { d.openAttempts = attempts }
+}
+
+// Selects the device with the given serial number, when several are connected
+func WithSerial(serial string) Option {
+	return func(d *deviceIds) { d.serial = serial }
+}
+
+// Selects the HID interface to open using matcher, instead of the usage page and
+// interface number; see ledger.WithMatcher()
+func WithMatcher(matcher ledger.DeviceMatcher) Option {
+	return func(d *deviceIds) { d.matcher = matcher }
+}
+
+// Helper function to apply options over the default identifiers
+func resolveDeviceIds(opts []Option) deviceIds {
+
+	ids := deviceIds{
+		LEDGER_VENDOR,
+		LEDGER_PRODUCTID,
+		LEDGER_IFACENUM,
+		LEDGER_USAGEPAGE,
+		ledger.DefaultOpenAttempts,
+		"",
+		nil,
+	}
+
+	for _, opt := range opts {
+		opt(&ids)
+	}
+
+	return ids
 }
 
 // Use the HID library to establish a connection to the ledger device. The
 // device will not appear to the USB subsystem until the ledger is unlocked
-// by entering the PIN code
-func Get() (*TezosLedger, error) {
+// by entering the PIN code. Options may be given to override the default
+// USB identifiers.
+func Get(opts ...Option) (*TezosLedger, error) {
+
+	ids := resolveDeviceIds(opts)
 
-	tezos, err := ledger.Get(LEDGER_VENDOR, LEDGER_PRODUCTID, LEDGER_IFACENUM, LEDGER_USAGEPAGE)
+	tezos, err := ledger.Get(ids.vendor, ids.product, ids.iface, ids.usagePage,
+		ledger.WithOpenAttempts(ids.openAttempts), ledger.WithSerial(ids.serial), ledger.WithMatcher(ids.matcher))
 	if err != nil {
 		return nil, err
 	}
 	return &TezosLedger{
-		tezos,
+		Ledger: tezos,
 	}, nil
 }
 
-// Instructs the HID library to close USB communications
-func (l *TezosLedger) Close() {
-	l.Dev.Close()
+// Same as Get(), but waits for the device to appear until ctx is done
+func GetWithTimeout(ctx context.Context, opts ...Option) (*TezosLedger, error) {
+
+	ids := resolveDeviceIds(opts)
+
+	tezos, err := ledger.GetWithTimeout(ctx, ids.vendor, ids.product, ids.iface, ids.usagePage,
+		ledger.WithOpenAttempts(ids.openAttempts), ledger.WithSerial(ids.serial), ledger.WithMatcher(ids.matcher))
+	if err != nil {
+		return nil, err
+	}
+	return &TezosLedger{
+		Ledger: tezos,
+	}, nil
+}
+
+// Instructs the HID library to close USB communications, and clears the
+// bip path. Safe to call more than once.
+func (l *TezosLedger) Close() error {
+
+	if l.Ledger == nil {
+		return nil
+	}
+
+	return l.Ledger.Close()
+}
+
+// Capabilities are feature flags which newer apps return after their version,
+// for feature detection which does not depend on comparing version numbers
+type Capabilities uint32
+
+const (
+	CapHMAC           Capabilities = 1 << iota // Supports GetHMAC
+	CapRoundWatermark                          // Watermarks include the Tenderbake round
+)
+
+// Reports whether all of the capabilities c are present
+func (caps Capabilities) Has(c Capabilities) bool {
+	return caps&c == c
+}
+
+// AppVersion is the parsed reply to the Version APDU
+type AppVersion struct {
+	Class               string // Wallet or Baking
+	Major, Minor, Patch uint8
+
+	// Only meaningful if HasCapabilities; apps returning the legacy 4 byte
+	// reply do not report capabilities
+	Capabilities    Capabilities
+	HasCapabilities bool
+}
+
+// Ex: Baking 2.2.1
+func (v AppVersion) String() string {
+	return fmt.Sprintf("%s %d.%d.%d", v.Class, v.Major, v.Minor, v.Patch)
+}
+
+// Reports whether the app signs blocks and (pre)endorsements without the user
+// pressing a button. Only the Baking app does, in every released version, once a
+// key is authorized; the Wallet app prompts for every signature, and so would
+// block a baker forever. Setup and authorization prompt on both apps.
+func (v AppVersion) AutoApprovesConsensus() bool {
+	return v.Class == "Baking"
+}
+
+// Reports whether the currently open app will wait for a button press before
+// signing consensus operations, ie: a daemon should warn the operator to open
+// the Baking app instead. See AppVersion.AutoApprovesConsensus()
+func (l *TezosLedger) PromptsForConsensus() (bool, error) {
+
+	v, err := l.GetAppVersion()
+	if err != nil {
+		return false, err
+	}
+
+	return !v.AutoApprovesConsensus(), nil
 }
 
 // Returns a version string of the currently open app
 // Ex: Baking 2.2.1
 func (l *TezosLedger) GetVersion() (string, error) {
 
+	v, err := l.GetAppVersion()
+	if err != nil {
+		return "", err
+	}
+
+	return v.String(), nil
+}
+
+// Returns the version of the currently open app, along with its capabilities if reported
+func (l *TezosLedger) GetAppVersion() (AppVersion, error) {
+
 	apdu := &TzApdu{
 		Version,
 		0x00,
@@ -78,22 +293,241 @@ func (l *TezosLedger) GetVersion() (string, error) {
 
 	_, err := l.Write(apdu, TEZOS_CHANNEL)
 	if err != nil {
-		return "", errors.Wrap(err, "Unable to get version")
+		return AppVersion{}, errors.Wrap(err, "Unable to get version")
 	}
 
 	resp, err := l.Read(TEZOS_CHANNEL)
 	if err != nil {
-		return "", errors.Wrap(err, "Unable to get version")
+		return AppVersion{}, errors.Wrap(err, "Unable to get version")
+	}
+
+	return parseAppVersion(resp)
+}
+
+// Helper function to parse the reply to the Version APDU
+//   class (1) | major (1) | minor (1) | patch (1) [| capability flags (up to 4, big endian)]
+func parseAppVersion(resp []byte) (AppVersion, error) {
+
+	if len(resp) < 4 {
+		return AppVersion{}, errors.New("Not enough data returned")
 	}
 
 	// https://github.com/LedgerHQ/app-tezos/blob/master/src/version.h
-	class := "Wallet"
+	v := AppVersion{
+		Class: "Wallet",
+		Major: resp[1],
+		Minor: resp[2],
+		Patch: resp[3],
+	}
+
 	if resp[0] == 1 {
-		class = "Baking"
+		v.Class = "Baking"
+	}
+
+	flags := resp[4:]
+	if len(flags) > 4 {
+		flags = flags[:4]
+	}
+
+	for _, b := range flags {
+		v.Capabilities = v.Capabilities<<8 | Capabilities(b)
+	}
+	v.HasCapabilities = len(flags) > 0
+
+	return v, nil
+}
+
+// Lightweight liveness probe. Issues the Version APDU and discards the result.
+// Returns an error matching ErrNoResponse if the device cannot be reached, such as
+// a ledger.TransportError or ledger.ErrTimeout, which remains in the chain. A reply
+// from the device other than success, ie: ledger.ErrWrongApp if the Tezos app is
+// not open, or ledger.ErrDeviceLocked, is returned as its *ledger.StatusError.
+// Otherwise nil.
+func (l *TezosLedger) Ping() error {
+
+	apdu := &TzApdu{
+		Version,
+		0x00,
+		0x00,
+		nil,
+	}
+
+	_, err := l.Write(apdu, TEZOS_CHANNEL)
+	if err != nil {
+		return &noResponseError{err}
+	}
+
+	_, err = l.Read(TEZOS_CHANNEL)
+
+	var statusErr *ledger.StatusError
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &statusErr):
+		return err
+	}
+
+	return &noResponseError{err}
+}
+
+// Failure to reach the device, matching ErrNoResponse with errors.Is() while
+// keeping the cause in the chain
+type noResponseError struct {
+	err error
+}
+
+func (e *noResponseError) Error() string {
+	return ErrNoResponse.Error() + ": " + e.err.Error()
+}
+
+func (e *noResponseError) Unwrap() error {
+	return e.err
+}
+
+func (e *noResponseError) Is(target error) bool {
+	return target == ErrNoResponse
+}
+
+// Probes the device with a Version request to confirm a Tezos app is open.
+// Returns ErrTezosAppNotOpen if the device answered that it doesn't understand
+// the request, as happens when the dashboard or another app is open.
+func (l *TezosLedger) EnsureTezosApp() error {
+
+	apdu := &TzApdu{
+		Version,
+		0x00,
+		0x00,
+		nil,
+	}
+
+	_, err := l.Write(apdu, TEZOS_CHANNEL)
+	if err != nil {
+		return errors.Wrap(err, "Unable to write version request")
+	}
+
+	_, err = l.Read(TEZOS_CHANNEL)
+	if err != nil {
+		if errors.Is(err, ledger.ErrWrongApp) || errors.Is(err, ledger.ErrUnsupportedIns) {
+			return ErrTezosAppNotOpen
+		}
+		return errors.Wrap(err, "Unable to read version reply")
 	}
-	verStr := fmt.Sprintf("%s %d.%d.%d", class, resp[1], resp[2], resp[3])
 
-	return verStr, nil
+	return nil
+}
+
+// Abandons a request the device is prompting the user to confirm, such as a signature
+// no longer wanted because a new block arrived. The pending call returns
+// ledger.ErrAborted promptly, instead of when the user responds or it times out, and
+// the HID handle is closed and reopened, so the abandoned exchange cannot leak into
+// the next request; see ledger.Ledger.Abort(). The app has no command to dismiss a
+// prompt, so it stays on screen until answered. An abort made just before the call
+// starts waiting is not lost. Safe to call from another goroutine.
+func (l *TezosLedger) AbortPrompt() error {
+
+	if l.Ledger == nil {
+		return ledger.ErrNoDevice
+	}
+
+	l.Abort()
+
+	return nil
+}
+
+// Sends data too long for a single APDU as a sequence of ins APDUs; P1Other for all
+// chunks but the last, which is sent with P1Last. Data which fits in one APDU is sent
+// as a single P1Last frame. The final reply is awaited in blocking mode, as the device
+// may be waiting on the user. Any preceding P1First frame, such as the bip path when
+// signing, is the caller's responsibility, as is wrapping it and SendChunks() in
+// BeginMultiPart() and EndMultiPart().
+// Returns the response to the last chunk, or error
+func (l *TezosLedger) SendChunks(ins, p2 uint8, data []byte) ([]byte, error) {
+	return l.sendChunks(ins, p2, data, maxCDataLen)
+}
+
+// Helper function for SendChunks() splitting data into chunks of at most size bytes
+func (l *TezosLedger) sendChunks(ins, p2 uint8, data []byte, size int) ([]byte, error) {
+
+	l.BeginMultiPart()
+	defer l.EndMultiPart()
+
+	last, err := l.writeLeadingChunks(ins, p2, data, size)
+	if err != nil {
+		return nil, err
+	}
+
+	lastApdu := &TzApdu{
+		ins,
+		P1Last,
+		p2,
+		last,
+	}
+
+	return l.signExchange(lastApdu)
+}
+
+// Helper function to send all but the last chunk of data, as P1Other APDUs of at most
+// size bytes, leaving the last to the caller
+// Returns the last chunk, or error
+func (l *TezosLedger) writeLeadingChunks(ins, p2 uint8, data []byte, size int) ([]byte, error) {
+
+	for len(data) > size {
+
+		chunkApdu := &TzApdu{
+			ins,
+			P1Other,
+			p2,
+			data[:size],
+		}
+
+		_, err := l.Write(chunkApdu, TEZOS_CHANNEL)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to write chunk")
+		}
+
+		_, err = l.Read(TEZOS_CHANNEL)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to read chunk reply")
+		}
+
+		data = data[size:]
+	}
+
+	return data, nil
+}
+
+// Sends an arbitrary APDU to the device and returns the response, with the status word
+// removed. This is an escape hatch for experimenting with instructions this library
+// doesn't wrap; it bypasses the typed helpers, and parsing the response is left to
+// the caller. Status words indicating failure are returned as errors.
+func (l *TezosLedger) SendAPDU(cla, ins, p1, p2 uint8, data []byte) ([]byte, error) {
+
+	if len(data) > maxCDataLen {
+		return nil, errors.Errorf("APDU data too long; %d > %d bytes", len(data), maxCDataLen)
+	}
+
+	apdu := rawApdu{
+		cla,
+		TzApdu{
+			ins,
+			p1,
+			p2,
+			data,
+		},
+	}
+
+	_, err := l.Write(apdu, TEZOS_CHANNEL)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to write APDU")
+	}
+
+	resp, err := l.Read(TEZOS_CHANNEL)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read APDU reply")
+	}
+
+	return resp, nil
 }
 
 // Returns the git commit hash of the currently open app
@@ -117,7 +551,32 @@ func (l *TezosLedger) GetCommitHash() (string, error) {
 		return "", errors.Wrap(err, "Unable to get commit hash")
 	}
 
-	return string(resp), nil
+	// App sends a NUL terminated string
+	commitHash := string(bytes.TrimRight(resp, "\x00"))
+
+	// Builds from a modified tree are suffixed with -dirty
+	if !matchCommitHash.MatchString(commitHash) {
+		return "", errors.Errorf("Invalid commit hash %q", commitHash)
+	}
+
+	return commitHash, nil
+}
+
+// Returns the git commit hash of the currently open app, decoded from hex
+// Ex: [178 140 35 100]
+func (l *TezosLedger) GetCommitHashBytes() ([]byte, error) {
+
+	commitHash, err := l.GetCommitHash()
+	if err != nil {
+		return nil, err
+	}
+
+	commitHashBytes, err := hex.DecodeString(strings.TrimSuffix(commitHash, "-dirty"))
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to decode commit hash")
+	}
+
+	return commitHashBytes, nil
 }
 
 // Prompts user to confirm the public key (edpk...), and public key hash (tz1..) of the currently set BipPath
@@ -126,66 +585,254 @@ func (l *TezosLedger) GetPublicKeyWithPrompt() (string, string, error) {
 	return l.getKey(PromptPubKey)
 }
 
+// Same as GetPublicKeyWithPrompt(), but stops waiting for the user when ctx is done,
+// such as for a setup wizard showing a countdown. Returns ErrConfirmTimeout if ctx's
+// deadline passes first. The device keeps displaying the key until the user answers;
+// have them reject it before prompting again.
+func (l *TezosLedger) GetPublicKeyWithPromptContext(ctx context.Context) (string, string, error) {
+
+	pk, pkh, _, err := l.getKeyRaw(ctx, PromptPubKey)
+	if errors.Is(err, ledger.ErrTimeout) && ctx.Err() != nil {
+		return "", "", ErrConfirmTimeout
+	}
+
+	return pk, pkh, err
+}
+
 // Returns the public key (edpk...), and public key hash (tz1..) of the currently set BipPath
 // Use SetBipPath() before calling this function.
 func (l *TezosLedger) GetPublicKey() (string, string, error) {
 	return l.getKey(GetPubKey)
 }
 
+// Confirms the key at the currently set BipPath, for the configured Curve, is that of
+// the expected address (tz1/tz2/tz3), such as before authorizing a device for a
+// known baker. Returns ErrAddressMismatch if not.
+// Use SetBipPath() before calling this function.
+func (l *TezosLedger) ConfirmAddress(expected string) error {
+
+	_, pkh, err := l.GetPublicKey()
+	if err != nil {
+		return errors.Wrap(err, "Unable to get public key")
+	}
+
+	if pkh != expected {
+		return errors.Wrapf(ErrAddressMismatch, "expected %s, device has %s", expected, pkh)
+	}
+
+	return nil
+}
+
+// Sets the bip path used by subsequent key and signing requests, after checking the
+// coin type if StrictCoinType is set
+func (l *TezosLedger) SetBipPath(path string) error {
+
+	if l.StrictCoinType {
+		if err := ledger.CheckCoinType(path, TEZOS_COIN_TYPE); err != nil {
+			return err
+		}
+	}
+
+	return l.Ledger.SetBipPath(path)
+}
+
+// Returns the standard Tezos bip path for an account and address index
+// Ex: StandardPath(0, 1) = /44'/1729'/0'/1'
+func StandardPath(account, addressIndex uint32) string {
+	return fmt.Sprintf("/44'/%d'/%d'/%d'", TEZOS_COIN_TYPE, account, addressIndex)
+}
+
+// Sets the bip path, and the curve of the key derived from it, used by subsequent
+// key and signing requests. SetBipPath() alone leaves the curve unchanged, which
+// is ED25519 unless set otherwise.
+func (l *TezosLedger) SetBipPathWithCurve(path string, curve Curve) error {
+
+	if curve > BIP32_ED25519 {
+		return errors.Errorf("Unknown curve 0x%02x", uint8(curve))
+	}
+
+	if err := l.SetBipPath(path); err != nil {
+		return err
+	}
+
+	l.Curve = curve
+
+	return nil
+}
+
+// Sets the bip path to the standard hardened path for an account and address index
+// Returns error if either index is not below ledger.HARDENED
+func (l *TezosLedger) SetBipPathIndexed(account, addressIndex uint32) error {
+
+	if account >= ledger.HARDENED || addressIndex >= ledger.HARDENED {
+		return errors.New("Invalid child index")
+	}
+
+	return l.SetBipPath(StandardPath(account, addressIndex))
+}
+
+// Returns the public key (edpk...), and public key hash (tz1..) of the given bip path,
+// without prompting on the device. The currently set bip path is left unchanged.
+func (l *TezosLedger) PublicKeyForPath(path string) (string, string, error) {
+
+	// Restore whichever path the caller had set, even on error
+	origBipPath, origPath := l.BipPath, l.BipPathString
+	defer func() {
+		l.BipPath, l.BipPathString = origBipPath, origPath
+	}()
+
+	if err := l.SetBipPath(path); err != nil {
+		return "", "", errors.Wrapf(err, "Unable to set bip path %s", path)
+	}
+
+	return l.GetPublicKey()
+}
+
+// AddressInfo is the public key and address derived at a bip path
+type AddressInfo struct {
+	Path      string
+	PublicKey string
+	Address   string
+}
+
+// Retrieves the public key and address for count consecutive bip paths of
+// the form /44'/1729'/account'/i', starting at i = 0, without prompting on
+// the device. The currently set bip path is left unchanged.
+func (l *TezosLedger) ScanAddresses(account int, count int) ([]AddressInfo, error) {
+
+	if account < 0 || int64(account) >= ledger.HARDENED {
+		return nil, errors.New("Invalid child index")
+	}
+
+	if count < 0 || int64(count) > ledger.HARDENED {
+		return nil, errors.Errorf("Invalid count %d", count)
+	}
+
+	addresses := make([]AddressInfo, 0, count)
+
+	for i := 0; i < count; i++ {
+
+		path := StandardPath(uint32(account), uint32(i))
+
+		pk, pkh, err := l.PublicKeyForPath(path)
+		if err != nil {
+			return addresses, errors.Wrapf(err, "Unable to get public key for %s", path)
+		}
+
+		addresses = append(addresses, AddressInfo{
+			Path:      path,
+			PublicKey: pk,
+			Address:   pkh,
+		})
+	}
+
+	return addresses, nil
+}
+
+// Same as GetPublicKey(), but also returns the unparsed response from the device
+// for diagnosing differences across app versions
+func (l *TezosLedger) GetPublicKeyRaw() (string, string, []byte, error) {
+	return l.getKeyRaw(context.Background(), GetPubKey)
+}
+
+// Returns the public key of the currently set BipPath as hex, in the form the device
+// provides it, for external verifiers which do not understand edpk/sppk/p2pk.
+// For ed25519 this is the 32 byte key; for secp256k1 and p256, the SEC1 encoded
+// point, which is uncompressed (0x04 | X | Y) on most app versions.
+// Use SetBipPath() before calling this function.
+func (l *TezosLedger) GetPublicKeyHex() (string, error) {
+
+	_, _, resp, err := l.getKeyRaw(context.Background(), GetPubKey)
+	if err != nil {
+		return "", err
+	}
+
+	key := resp[1:]
+
+	// The format byte carries no information for ed25519
+	if l.Curve == ED25519 || l.Curve == BIP32_ED25519 {
+		key = key[1:]
+	}
+
+	return hex.EncodeToString(key), nil
+}
+
+// Returns the raw public key of the currently set BipPath, for external libraries
+// which need it rather than edpk/sppk/p2pk. The format depends on the curve:
+//   ed25519:          the 32 byte key
+//   secp256k1, p256:  the 65 byte uncompressed SEC1 point, 0x04 | X (32) | Y (32)
+// Unlike GetPublicKeyHex(), secp keys are always uncompressed, whichever form the
+// app version returns.
+// Use SetBipPath() before calling this function.
+func (l *TezosLedger) GetPublicKeyUncompressed() ([]byte, error) {
+
+	_, _, resp, err := l.getKeyRaw(context.Background(), GetPubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return uncompressedPublicKey(l.Curve, resp[1:])
+}
+
 // Internal helper function to retrieve public key from device.
 func (l *TezosLedger) getKey(ins uint8) (string, string, error) {
+	pk, pkh, _, err := l.getKeyRaw(context.Background(), ins)
+	return pk, pkh, err
+}
+
+// Internal helper function to retrieve public key from device, along with the raw response
+func (l *TezosLedger) getKeyRaw(ctx context.Context, ins uint8) (string, string, []byte, error) {
 
 	if len(l.BipPath) == 0 {
-		return "", "", errors.New("No BIP Path is set; Use SetBipPath()")
+		return "", "", nil, errors.New("No BIP Path is set; Use SetBipPath()")
 	}
 
 	apdu := &TzApdu{
 		ins,
 		0x00,
-		0x00,
+		byte(l.Curve),
 		l.BipPath,
 	}
 
 	_, err := l.Write(apdu, TEZOS_CHANNEL)
 	if err != nil {
-		return "", "", errors.Wrap(err, "Unable to write key request")
+		return "", "", nil, errors.Wrap(err, "Unable to write key request")
 	}
 
-	resp, err := l.Read(TEZOS_CHANNEL)
+	resp, err := l.ReadContext(ctx, TEZOS_CHANNEL)
 	if err != nil {
-		return "", "", errors.Wrap(err, "Unable to read key request")
+		return "", "", nil, errors.Wrap(err, "Unable to read key request")
+	}
+
+	if len(resp) == 0 {
+		return "", "", resp, ErrLengthZero
 	}
 
 	// First byte is length info
 	_respLength, bRead := binary.Uvarint(resp[:1])
 	if bRead != 1 {
-		return "", "", ErrDecodeLength
+		return "", "", resp, ErrDecodeLength
 	}
 	respLength := int(_respLength)  // Convert from uint64
 
 	// Check if lengths match what ledger tells us
 	if respLength != len(resp[1:]) {
-		return "", "", ErrLengthMismatch
+		return "", "", resp, ErrLengthMismatch
 	}
 
 	// Nothing returned? Bail
 	if respLength == 0 {
-		return "", "", ErrLengthZero
+		return "", "", resp, ErrLengthZero
 	}
 
-	// No idea what the 0x02 value at resp[1] is for, but definitely
-	// not part of the key and not part of the length check. Ignore it.
-
-	// PK comes directly from device without prefix/watermark
-	pk := ledger.B58cencode(resp[2:], edpkprefix)
-
-	// Convert PK to PKH
-	pkh, err := pkhFromPkBytes(resp[2:])
+	// PK comes directly from device without prefix/watermark, and in
+	// a curve specific format
+	pk, pkh, err := encodeDevicePublicKey(l.Curve, resp[1:])
 	if err != nil {
-		return pk, "", err
+		return pk, "", resp, err
 	}
 
-	return pk, pkh, nil
+	return pk, pkh, resp, nil
 }
 
 // Setup ledger to bake on a specific chain, starting at a specific high-level watermark,
@@ -194,8 +841,32 @@ func (l *TezosLedger) getKey(ins uint8) (string, string, error) {
 // Returns the authorized public key (edpk...), and public key hash (tz1..), or error
 func (l *TezosLedger) SetupBaking(chainId string, hlwm int) (string, string, error) {
 
+	res, err := l.SetupBakingResult(chainId, hlwm)
+	if err != nil {
+		return res.PublicKey, "", err
+	}
+
+	return res.PublicKey, res.PublicKeyHash, nil
+}
+
+// SetupResult is the reply to BakingSetup: the authorized key and, on apps which
+// echo them, the watermarks and chain the device applied
+type SetupResult struct {
+	PublicKey     string
+	PublicKeyHash string
+
+	// Only meaningful if HasWatermarks; older apps reply with the key alone
+	Watermarks    Watermarks
+	HasWatermarks bool
+}
+
+// Same as SetupBaking(), but also returns the watermarks echoed by newer apps, so
+// that provisioning tools can confirm the device applied the requested chain and
+// level, rather than assuming it did
+func (l *TezosLedger) SetupBakingResult(chainId string, hlwm int) (SetupResult, error) {
+
 	if len(l.BipPath) == 0 {
-		return "", "", errors.New("No BIP Path is set; Use SetBipPath()")
+		return SetupResult{}, errors.New("No BIP Path is set; Use SetBipPath()")
 	}
 	//fmt.Println(l.BipPath)
 
@@ -216,43 +887,81 @@ func (l *TezosLedger) SetupBaking(chainId string, hlwm int) (string, string, err
 	apdu := &TzApdu{
 		BakingSetup,
 		0x00,
-		0x00,
+		byte(l.Curve),
 		cdata,
 	}
 
 	_, err := l.Write(apdu, TEZOS_CHANNEL)
 	if err != nil {
-		return "", "", err
+		return SetupResult{}, err
 	}
 
 	resp, err := l.Read(TEZOS_CHANNEL)
 	if err != nil {
-		return "", "", errors.Wrap(err, "Unable to read baking setup response")
+		return SetupResult{}, errors.Wrap(err, "Unable to read baking setup response")
+	}
+
+	return parseSetupResponse(l.Curve, resp)
+}
+
+// Helper function to parse the reply to BakingSetup
+//   key length (1) | key [| watermarks, as returned by GetBakingHLW]
+func parseSetupResponse(curve Curve, resp []byte) (SetupResult, error) {
+
+	if len(resp) == 0 {
+		return SetupResult{}, ErrLengthZero
 	}
 
 	// First byte is length info
 	respLength, bRead := binary.Uvarint(resp[:1])
 	if bRead != 1 {
-		return "", "", ErrDecodeLength
+		return SetupResult{}, ErrDecodeLength
 	}
 
-	if int(respLength) != len(resp[1:]) {
-		return "", "", ErrLengthMismatch
+	if int(respLength) > len(resp[1:]) {
+		return SetupResult{}, ErrLengthMismatch
 	}
 
-	// No idea what the 0x02 value at resp[1] is for, but definitely
-	// not part of the key and not part of the length check. Ignore it.
-
-	// PK comes directly from device without prefix/watermark
-	pk := ledger.B58cencode(resp[2:], edpkprefix)
+	key, echo := resp[1:1+respLength], resp[1+respLength:]
 
-	// Convert PK to PKH
-	pkh, err := pkhFromPkBytes(resp[2:])
+	// PK comes directly from device without prefix/watermark, and in
+	// a curve specific format, beginning with a format tag at key[0]
+	pk, pkh, err := encodeDevicePublicKey(curve, key)
 	if err != nil {
-		return pk, "", err
+		return SetupResult{PublicKey: pk}, err
 	}
 
-	return pk, pkh, nil
+	res := SetupResult{
+		PublicKey:     pk,
+		PublicKeyHash: pkh,
+	}
+
+	if len(echo) > 0 {
+
+		if res.Watermarks, err = parseWatermarks(echo); err != nil {
+			return SetupResult{}, errors.Wrap(err, "Unable to parse setup watermarks")
+		}
+		res.HasWatermarks = true
+	}
+
+	return res, nil
+}
+
+// Deauthorizes any currently authorized key, then sets up baking as SetupBaking() does.
+// This is the safe sequence for re-provisioning a device with a new baking key.
+// Failure to deauthorize is tolerated, as a fresh device has nothing to deauthorize,
+// unless the user denied it or the Tezos app is not open.
+// Use SetBipPath() before calling this function.
+// Returns the authorized public key (edpk...), and public key hash (tz1..), or error
+func (l *TezosLedger) Reauthorize(chainId string, hlwm int) (string, string, error) {
+
+	if err := l.DeauthorizeBaking(); err != nil {
+		if errors.Is(err, ledger.ErrUserDenied) || errors.Is(err, ledger.ErrWrongApp) {
+			return "", "", errors.Wrap(err, "Unable to deauthorize")
+		}
+	}
+
+	return l.SetupBaking(chainId, hlwm)
 }
 
 // Authorizes the current BipPath address to sign block and endorsement operations.
@@ -267,7 +976,7 @@ func (l *TezosLedger) AuthorizeBaking() (string, string, error) {
 	apdu := &TzApdu{
 		AuthBaking,
 		0x00,
-		0x00,
+		byte(l.Curve),
 		l.BipPath,
 	}
 
@@ -281,6 +990,10 @@ func (l *TezosLedger) AuthorizeBaking() (string, string, error) {
 		return "", "", errors.Wrap(err, "Unable to read auth request")
 	}
 
+	if len(resp) == 0 {
+		return "", "", ErrLengthZero
+	}
+
 	// First byte is length info
 	respLength, bRead := binary.Uvarint(resp[:1])
 	if bRead != 1 {
@@ -291,13 +1004,9 @@ func (l *TezosLedger) AuthorizeBaking() (string, string, error) {
 		return "", "", ErrLengthMismatch
 	}
 
-	// No idea what the 0x02 value at resp[1] is for, but definitely
-	// not part of the key and not part of the length check. Ignore it.
-
-	pk := ledger.B58cencode(resp[2:], edpkprefix)
-
-	// Convert PK to PKH
-	pkh, err := pkhFromPkBytes(resp[2:])
+	// PK comes directly from device without prefix/watermark, and in
+	// a curve specific format, beginning with a format tag at resp[1]
+	pk, pkh, err := encodeDevicePublicKey(l.Curve, resp[1:])
 	if err != nil {
 		return pk, "", err
 	}
@@ -333,8 +1042,44 @@ func (l *TezosLedger) DeauthorizeBaking() error {
 // Reset all watermarks to a given level. User must allow this action on device.
 // Returns nothing on success, error otherwise
 func (l *TezosLedger) ResetBakingHLW(newLevel int) error {
+	return l.ResetBakingHLWRound(newLevel, 0)
+}
+
+// Reset all watermarks to a given level and round, such as when restoring a backup.
+// User must allow this action on device. Only the Baking app can reset watermarks,
+// and its reset handler, in every version, takes the level alone and sets both
+// rounds to 0:
+//   level (4)
+// https://github.com/LedgerHQ/app-tezos/blob/master/src/apdu_reset.c
+// https://github.com/trilitech/ledger-app-tezos-baking/blob/main/src/apdu_reset.c
+// No app can restore a later round, so round must be 0; resetting to round 0 of a
+// level still refuses a block at that level and round.
+// Returns ErrNotBakingApp, ErrResetRound, or error from the device
+func (l *TezosLedger) ResetBakingHLWRound(newLevel, round int) error {
+
+	if newLevel < 0 || round < 0 {
+		return ErrNegative
+	}
+
+	if round > 0 {
+		return ErrResetRound
+	}
+
+	// The app refuses levels with the top bit set
+	if int64(newLevel) > math.MaxInt32 {
+		return errors.Errorf("Level %d out of range", newLevel)
+	}
+
+	v, err := l.GetAppVersion()
+	if err != nil {
+		return errors.Wrap(err, "Unable to check app before reset")
+	}
 
-	var b = make([]byte, 4)
+	if v.Class != "Baking" {
+		return errors.Wrapf(ErrNotBakingApp, "Open app is %s", v)
+	}
+
+	b := make([]byte, 4)
 	binary.BigEndian.PutUint32(b, uint32(newLevel))
 
 	apdu := &TzApdu{
@@ -344,7 +1089,7 @@ func (l *TezosLedger) ResetBakingHLW(newLevel int) error {
 		b,
 	}
 
-	_, err := l.Write(apdu, TEZOS_CHANNEL)
+	_, err = l.Write(apdu, TEZOS_CHANNEL)
 	if err != nil {
 		return err
 	}
@@ -361,6 +1106,13 @@ func (l *TezosLedger) ResetBakingHLW(newLevel int) error {
 // Query all watermarks
 // Returns current watermarks for main and test chain, along with main chain id
 func (l *TezosLedger) GetBakingSetup() (uint32, uint32, string, error) {
+	mainWM, testWM, chainId, _, err := l.GetBakingSetupRaw()
+	return mainWM, testWM, chainId, err
+}
+
+// Same as GetBakingSetup(), but also returns the unparsed response from the device
+// for diagnosing differences across app versions
+func (l *TezosLedger) GetBakingSetupRaw() (uint32, uint32, string, []byte, error) {
 
 	apdu := &TzApdu{
 		GetBakingHLW,
@@ -371,29 +1123,20 @@ func (l *TezosLedger) GetBakingSetup() (uint32, uint32, string, error) {
 
 	_, err := l.Write(apdu, TEZOS_CHANNEL)
 	if err != nil {
-		return 0, 0, "", err
+		return 0, 0, "", nil, err
 	}
 
 	resp, err := l.Read(TEZOS_CHANNEL)
 	if err != nil {
-		return 0, 0, "", errors.Wrap(err, "Unable to read HLW reply")
+		return 0, 0, "", nil, errors.Wrap(err, "Unable to read HLW reply")
 	}
 
-	if len(resp) < 12 {
-		return 0, 0, "", errors.New("Not enough data returned")
+	wm, err := parseWatermarks(resp)
+	if err != nil {
+		return 0, 0, "", resp, err
 	}
 
-	// First 4 bytes, uint32 HLW of main chain
-	mainWM := binary.BigEndian.Uint32(resp[:4])
-
-	// Second 4 bytes, uint32 HLW of test chain
-	testWM := binary.BigEndian.Uint32(resp[4:8])
-
-	// Last 4 bytes, hex-bytes of main chain id without prefix
-	// B58 encode with proper prefix
-	chainId := ledger.B58cencode(resp[8:12], networkprefix)
-
-	return mainWM, testWM, chainId, nil
+	return wm.Main.Level, wm.Test.Level, wm.ChainID, resp, nil
 }
 
 // Returns the Bip32 key path of the currently authorized baking address
@@ -416,8 +1159,7 @@ func (l *TezosLedger) GetAuthorizedKeyPath() (string, error) {
 		return "", errors.Wrap(err, "Unable to read auth request")
 	}
 
-	// Ex: [4 128 0 0 44 128 0 6 193 128 0 0 0 128 0 0 0]
-	bipPath, err := ledger.DecodeBipPath(resp)
+	bipPath, _, err := parseAuthorizedKey(resp)
 	if err != nil {
 		return "", err
 	}
@@ -425,6 +1167,82 @@ func (l *TezosLedger) GetAuthorizedKeyPath() (string, error) {
 	return bipPath, nil
 }
 
+// Helper function to parse the reply to a query for the authorized key. Depending on
+// app version, the path may be preceded by the curve of the key.
+//   [4 128 0 0 44 128 0 6 193 128 0 0 0 128 0 0 0]    path only
+//   [0 4 128 0 0 44 128 0 6 193 128 0 0 0 128 0 0 0]  curve, then path
+// The form is told apart by which leading byte correctly gives the path length.
+// An empty reply, or an empty path, means no key is authorized.
+// Returns bip path, curve (ED25519 if not present), or error
+func parseAuthorizedKey(resp []byte) (string, Curve, error) {
+
+	var curve Curve
+
+	switch {
+	case len(resp) == 0, len(resp) == 1 && resp[0] == 0, len(resp) == 2 && resp[1] == 0:
+		return "", 0, ErrNotAuthorized
+
+	case len(resp) >= 1 && len(resp) == 1 + 4 * int(resp[0]):
+		// Path only
+
+	case len(resp) >= 2 && len(resp) == 2 + 4 * int(resp[1]):
+		curve = Curve(resp[0])
+		if curve > BIP32_ED25519 {
+			return "", 0, errors.Errorf("Unknown curve 0x%02x", resp[0])
+		}
+		resp = resp[1:]
+
+	default:
+		return "", 0, errors.Errorf("Invalid authorized key reply (%d bytes)", len(resp))
+	}
+
+	bipPath, err := ledger.DecodeBipPath(resp)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return bipPath, curve, nil
+}
+
+// AuthorizedKey describes the key currently authorized for baking
+type AuthorizedKey struct {
+	Path  string
+	Curve Curve
+}
+
+// Returns the Bip32 key path and derivation type of the currently authorized baking address
+func (l *TezosLedger) GetAuthorizedKey() (AuthorizedKey, error) {
+
+	apdu := &TzApdu{
+		QueryBakingKey,
+		0x00,
+		0x00,
+		nil,
+	}
+
+	_, err := l.Write(apdu, TEZOS_CHANNEL)
+	if err != nil {
+		return AuthorizedKey{}, err
+	}
+
+	resp, err := l.Read(TEZOS_CHANNEL)
+	if err != nil {
+		return AuthorizedKey{}, errors.Wrap(err, "Unable to read auth request")
+	}
+
+	// First byte is the curve, remainder is the path
+	// Ex: [0 4 128 0 0 44 128 0 6 193 128 0 0 0 128 0 0 0]
+	bipPath, curve, err := parseAuthorizedKey(resp)
+	if err != nil {
+		return AuthorizedKey{}, err
+	}
+
+	return AuthorizedKey{
+		Path:  bipPath,
+		Curve: curve,
+	}, nil
+}
+
 // Generic signing function. Bakes, nonces, and endorsements cannot be signed by the wallet
 // app, and generic messages cannot be signed by the baking app.
 // Device will sign the given bytes using the registered bip path
@@ -432,6 +1250,43 @@ func (l *TezosLedger) GetAuthorizedKeyPath() (string, error) {
 // Returns signature of signed bytes or error
 func (l *TezosLedger) SignBytes(bytesToSign []byte) (string, error) {
 
+	resp, err := l.sign(SignBytes, bytesToSign)
+	if err != nil {
+		return "", err
+	}
+
+	// What returns from the ledger is the raw bytes of the signature for ed25519,
+	// or DER for secp256k1/p256. Need to b58cencode(rawBytes, prefix.edsig) to
+	// see human-readable signature
+	return encodeDeviceSignature(l.Curve, resp)
+}
+
+// Same as SignBytes(), but the device also returns the blake2b hash of the signed bytes
+// Returns signature of signed bytes, the 32 byte hash computed by the device, or error
+func (l *TezosLedger) SignBytesWithHash(bytesToSign []byte) (string, []byte, error) {
+
+	resp, err := l.sign(SignBytesWithHash, bytesToSign)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Hash is followed by the signature
+	if len(resp) <= 32 {
+		return "", nil, errors.New("Not enough data returned")
+	}
+
+	signature, err := encodeDeviceSignature(l.Curve, resp[32:])
+	if err != nil {
+		return "", nil, err
+	}
+
+	return signature, resp[:32], nil
+}
+
+// Internal helper to perform the signing exchange for the ins signing instruction
+// Returns the raw response, or error
+func (l *TezosLedger) sign(ins uint8, bytesToSign []byte) ([]byte, error) {
+
 	// Signing endorsement/bytes requires first sending a signing request
 	// with the BIP32 path to use, followed by a second APDU containing
 	// another signing request with the endorsement bytes.
@@ -440,58 +1295,117 @@ func (l *TezosLedger) SignBytes(bytesToSign []byte) (string, error) {
 	//
 
 	if len(l.BipPath) == 0 {
-		return "", errors.New("No BIP Path is set; Use SetBipPath()")
+		return nil, errors.New("No BIP Path is set; Use SetBipPath()")
 	}
 
+	// Try to halve the round trips if the app supports it
+	if l.SinglePhaseSigning && !l.singlePhaseUnsupported && len(l.BipPath) + len(bytesToSign) <= maxCDataLen {
+
+		resp, err := l.signSinglePhase(ins, bytesToSign)
+
+		switch {
+		case err == nil && l.isSignatureReply(ins, resp):
+			return resp, nil
+
+		case err == nil:
+			// Apps which ignore P1=0x80 take the request as the first of two,
+			// parse only the bip path, and answer with an empty success
+
+		case !errors.Is(err, ledger.ErrIncorrectParams) && !errors.Is(err, ledger.ErrWrongLength):
+			// Only fall back if the app didn't understand the request. Anything
+			// else, such as the user denying the operation, is returned as-is.
+			return nil, err
+		}
+
+		l.singlePhaseUnsupported = true
+	}
+
+	// The path and data must be sent again together; see ledger.BeginMultiPart()
+	l.BeginMultiPart()
+	defer l.EndMultiPart()
+
 	signingApdu := &TzApdu{
-		SignBytes,
-		0x00,
+		ins,
 		0x00,
+		byte(l.Curve),
 		l.BipPath,
 	}
 
 	_, err := l.Write(signingApdu, TEZOS_CHANNEL)
 	if err != nil {
-		return "", errors.Wrap(err, "Unable to sign bytes (1)")
+		return nil, errors.Wrap(err, "Unable to sign bytes (1)")
 	}
 
-	resp, err := l.Read(TEZOS_CHANNEL)
+	_, err = l.Read(TEZOS_CHANNEL)
 	if err != nil {
-		return "", errors.Wrap(err, "Unable to read bytes signature (1)")
+		return nil, errors.Wrap(err, "Unable to read bytes signature (1)")
 	}
-	//fmt.Println("S1_RESP:", resp)
-	//fmt.Println()
 
-	// Part 2
-	signBytesApdu := &TzApdu{
-		SignBytes,
-		0x81,
-		0x00,
-		bytesToSign,
+	// Part 2, split over several APDUs if too long for one
+	return l.SendChunks(ins, 0x00, bytesToSign)
+}
+
+// Sends the bip path and bytes to sign in one APDU, marked as both first and last
+// Returns the raw response, or error
+func (l *TezosLedger) signSinglePhase(ins uint8, bytesToSign []byte) ([]byte, error) {
+
+	cdata := make([]byte, 0, len(l.BipPath) + len(bytesToSign))
+	cdata = append(cdata, l.BipPath...)
+	cdata = append(cdata, bytesToSign...)
+
+	signApdu := &TzApdu{
+		ins,
+		0x80,
+		byte(l.Curve),
+		cdata,
+	}
+
+	return l.signExchange(signApdu)
+}
+
+// Helper function to check resp to the ins signing instruction carries a signature,
+// preceded by the hash for SignBytesWithHash, for the current curve
+func (l *TezosLedger) isSignatureReply(ins uint8, resp []byte) bool {
+
+	if ins == SignBytesWithHash {
+
+		if len(resp) <= 32 {
+			return false
+		}
+		resp = resp[32:]
 	}
 
-	if r, err := l.Dev.SetNonBlocking(false); r == -1 {
-		return "", errors.Wrap(err, "Could not set non-blocking")
+	_, err := encodeDeviceSignature(l.Curve, resp)
+
+	return err == nil
+}
+
+// Writes the signing apdu carrying the bytes to sign, and waits for the signature in
+// blocking mode, as the device may be waiting on the user. Non-blocking mode is always
+// restored, even if the exchange fails, so that it cannot leak into later exchanges.
+// Neither mode is set if DisableBlockingToggle is.
+// Returns the raw response, or error
+func (l *TezosLedger) signExchange(signApdu *TzApdu) (resp []byte, err error) {
+
+	if r, err := l.SetNonBlocking(false); r == -1 {
+		return nil, errors.Wrap(err, "Could not set blocking")
 	}
 
-	_, err = l.Write(signBytesApdu, TEZOS_CHANNEL)
+	defer func() {
+		if r, restoreErr := l.SetNonBlocking(true); r == -1 && err == nil {
+			resp, err = nil, errors.Wrap(restoreErr, "Could not set non-blocking")
+		}
+	}()
+
+	_, err = l.Write(signApdu, TEZOS_CHANNEL)
 	if err != nil {
-		return "", errors.Wrap(err, "Unable to sign bytes (2)")
+		return nil, errors.Wrap(err, "Unable to sign bytes")
 	}
 
 	resp, err = l.Read(TEZOS_CHANNEL)
 	if err != nil {
-		return "", errors.Wrap(err, "Unable to read bytes signature")
+		return nil, errors.Wrap(err, "Unable to read bytes signature")
 	}
 
-	if r, err := l.Dev.SetNonBlocking(true); r == -1 {
-		return "", errors.Wrap(err, "Could not set non-blocking")
-	}
-
-	//fmt.Println(resp)
-	//fmt.Println(hex.EncodeToString(resp))
-
-	// What returns from the ledger is the raw bytes of the signature.
-	// Need to b58cencode(rawBytes, prefix.edsig) to see human-readable signature
-	return ledger.B58cencode(resp, edsigprefix), nil
+	return resp, nil
 }
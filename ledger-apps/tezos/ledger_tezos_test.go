@@ -1,36 +1,108 @@
 package tezos
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/big"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 	"os"
+
+	ledger "github.com/bakingbacon/goledger"
+	log "github.com/sirupsen/logrus"
 )
 
 const (
 	CUR_VER  = "2.2.9"
 	CUR_HASH = "b28c2364"
+
+	BENCH_BIP_PATH = "/44'/1729'/0'/0'"
+
+	// Baking app must be set up for this chain, at a level below BENCH_LEVEL
+	BENCH_CHAIN_ID = "NetXdQprcVkpaWU"
+	BENCH_BRANCH   = "a8f5ce1c3c1a1a6f4bd1d3dd1d1aa7df1b2a4a6d1b8e42d4e3e0e5b2cbb1f4a6"
+	BENCH_LEVEL    = 2000000
+
+	// Forged 1 tez transaction between two tz1 addresses
+	BENCH_TX_HEX = "a8f5ce1c3c1a1a6f4bd1d3dd1d1aa7df1b2a4a6d1b8e42d4e3e0e5b2cbb1f4a6" +
+		"6c0002298c03ed7d454a101eb7022bc95f7e5f41ac78e807010a00c0843d" +
+		"000002298c03ed7d454a101eb7022bc95f7e5f41ac7800"
 )
 
 var tledger *TezosLedger
 
+// Set LEDGER_RECORD to a fixture file to record the device session while testing,
+// or LEDGER_REPLAY to test offline against a recorded session, ie:
+//   LEDGER_REPLAY=testdata/session.json go test -run 'TestGetVersion|TestGetCommitHash'
 func TestMain(m *testing.M) {
 
 	var err error
 
-	// Get device
+	if replay := os.Getenv("LEDGER_REPLAY"); replay != "" {
+
+		fixtures, err := ledger.LoadFixtures(replay)
+		if err != nil {
+			fmt.Printf("Cannot load fixtures: %s\n", err)
+			os.Exit(1)
+		}
+
+		tledger = &TezosLedger{
+			Ledger: &ledger.Ledger{
+				Dev: ledger.NewReplayer(fixtures),
+			},
+		}
+
+		os.Exit(m.Run())
+	}
+
+	// Get device. Without one, only the tests which need it are skipped
 	tledger, err = Get()
 	if err != nil {
-		fmt.Printf("Cannot get Ledger device: %s\n", err)
-		os.Exit(1)
+		fmt.Printf("Cannot get Ledger device, skipping device tests: %s\n", err)
+		tledger = nil
+		os.Exit(m.Run())
 	}
 	defer tledger.Close()
 
-	os.Exit(m.Run())
+	record := os.Getenv("LEDGER_RECORD")
+	if record == "" {
+		os.Exit(m.Run())
+	}
+
+	recorder := ledger.NewRecorder(tledger.Dev)
+	tledger.Dev = recorder
+
+	code := m.Run()
+
+	if err := recorder.Fixtures().Save(record); err != nil {
+		fmt.Printf("Cannot save fixtures: %s\n", err)
+		code = 1
+	}
+
+	os.Exit(code)
+}
+
+// Skips tests and benchmarks which need a device, or fixtures, when there is none
+func requireDevice(tb testing.TB) {
+	if tledger == nil {
+		tb.Skip("No Ledger device")
+	}
 }
 
 func TestGetVersion(t *testing.T) {
 
+	requireDevice(t)
+
 	ver, err := tledger.GetVersion()
 	if err != nil {
 		t.Errorf("Cannot get version: %s\n", err)
@@ -43,6 +115,8 @@ func TestGetVersion(t *testing.T) {
 
 func TestGetCommitHash(t *testing.T) {
 
+	requireDevice(t)
+
 	commitHash, err := tledger.GetCommitHash()
 	if err != nil {
 		t.Errorf("Cannot get commit hash: %s\n", err)
@@ -52,3 +126,1767 @@ func TestGetCommitHash(t *testing.T) {
 		t.Errorf("Expecting '%s'; Got %s", CUR_HASH, commitHash)
 	}
 }
+
+func TestSignOperationOutputJSON(t *testing.T) {
+
+	out := SignOperationOutput{
+		SignedOperation: "aabb",
+		Signature:       "ccdd",
+		EDSig:           "edsig1",
+		OperationHash:   "op1",
+	}
+
+	j, err := json.Marshal(out)
+	if err != nil {
+		t.Errorf("Cannot marshal output: %s\n", err)
+	}
+
+	expected := `{"signed_operation":"aabb","signature":"ccdd","edsig":"edsig1","operation_hash":"op1"}`
+	if string(j) != expected {
+		t.Errorf("Expecting %s; Got %s", expected, string(j))
+	}
+
+	// Blocks and consensus operations have no operation hash
+	out.OperationHash = ""
+
+	j, _ = json.Marshal(out)
+
+	expected = `{"signed_operation":"aabb","signature":"ccdd","edsig":"edsig1"}`
+	if string(j) != expected {
+		t.Errorf("Expecting %s; Got %s", expected, string(j))
+	}
+}
+
+func TestOperationHashOnlyForOperations(t *testing.T) {
+
+	path, _ := hex.DecodeString("048000002c800006c18000000080000000")
+	ok := []byte{0x90, 0x00}
+	der := []byte{0x30, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01, 0x01}
+
+	// Watermark, chain id NetXdQprcVkpaWU, then the block header
+	block := []byte{0x01, 0x7a, 0x06, 0xa7, 0x70, 0x00, 0x00, 0x00, 0x02, 0x01}
+	transaction := []byte{0x03, 0xaa, 0xbb}
+
+	l := replayLedger(t,
+		[2][]byte{append([]byte{CLA, SignBytes, P1First, byte(SECP256K1), byte(len(path))}, path...), ok},
+		[2][]byte{append([]byte{CLA, SignBytes, P1Last, 0x00, byte(len(block))}, block...), append(der, ok...)},
+		[2][]byte{append([]byte{CLA, SignBytes, P1First, byte(SECP256K1), byte(len(path))}, path...), ok},
+		[2][]byte{append([]byte{CLA, SignBytes, P1Last, 0x00, byte(len(transaction))}, transaction...), append(der, ok...)},
+	)
+
+	if err := l.SetBipPathWithCurve(BENCH_BIP_PATH, SECP256K1); err != nil {
+		t.Fatalf("Cannot set bip path: %s\n", err)
+	}
+
+	out, err := l.SignBlock("0000000201", BENCH_CHAIN_ID)
+	if err != nil {
+		t.Fatalf("Cannot sign block: %s\n", err)
+	}
+
+	if out.OperationHash != "" {
+		t.Errorf("Expecting no operation hash for a block; Got %s", out.OperationHash)
+	}
+
+	out, err = l.SignTransaction("aabb")
+	if err != nil {
+		t.Fatalf("Cannot sign transaction: %s\n", err)
+	}
+
+	if !strings.HasPrefix(out.OperationHash, "o") {
+		t.Errorf("Expecting operation hash for a transaction; Got %q", out.OperationHash)
+	}
+}
+
+// Every signature requires confirmation on the device, so these benchmarks
+// measure the host-side overhead of a session against individual calls.
+func BenchmarkSignTransaction(b *testing.B) {
+
+	requireDevice(b)
+
+	if err := tledger.SetBipPath(BENCH_BIP_PATH); err != nil {
+		b.Fatalf("Cannot set bip path: %s\n", err)
+	}
+
+	for i := 0; i < b.N; i++ {
+		if _, err := tledger.SignTransaction(BENCH_TX_HEX); err != nil {
+			b.Fatalf("Cannot sign transaction: %s\n", err)
+		}
+	}
+}
+
+func BenchmarkSignSession(b *testing.B) {
+
+	requireDevice(b)
+
+	session, err := tledger.NewSignSession(BENCH_BIP_PATH)
+	if err != nil {
+		b.Fatalf("Cannot open session: %s\n", err)
+	}
+	defer session.Close()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := session.Sign(BENCH_TX_HEX); err != nil {
+			b.Fatalf("Cannot sign transaction: %s\n", err)
+		}
+	}
+}
+
+func TestAddressFromPublicKey(t *testing.T) {
+
+	addr, err := AddressFromPublicKey("edpkuBknW28nW72KG6RoHtYW7p12T6GKc7nAbwYX5m8Wd9sDVC9yav")
+	if err != nil {
+		t.Errorf("Cannot compute address: %s\n", err)
+	}
+
+	if addr != "tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx" {
+		t.Errorf("Expecting tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx; Got %s", addr)
+	}
+}
+
+func TestForgeDelegation(t *testing.T) {
+
+	forged, err := ForgeDelegation("tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx", "tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx", 1257, 1, 10000, 0)
+	if err != nil {
+		t.Errorf("Cannot forge delegation: %s\n", err)
+	}
+
+	expected := "6e0002298c03ed7d454a101eb7022bc95f7e5f41ac78e90901904e00ff0002298c03ed7d454a101eb7022bc95f7e5f41ac78"
+	if forged != expected {
+		t.Errorf("Expecting %s; Got %s", expected, forged)
+	}
+
+	if _, err := ForgeDelegation("KT1PWx2mnDueood7fEmfbBDKx1D9BAnnXitn", "", 0, 0, 0, 0); err == nil {
+		t.Errorf("Expecting error for originated source")
+	}
+}
+
+func TestWatermarkGuard(t *testing.T) {
+
+	g := NewWatermarkGuard()
+
+	if err := g.Check(EndorsementWatermark, 100, 0); err != nil {
+		t.Errorf("Expecting first check to pass: %s\n", err)
+	}
+
+	if err := g.Check(EndorsementWatermark, 100, 1); err != nil {
+		t.Errorf("Expecting higher round to pass: %s\n", err)
+	}
+
+	var wmErr *ErrWatermark
+	if err := g.Check(EndorsementWatermark, 100, 1); !errors.As(err, &wmErr) {
+		t.Errorf("Expecting *ErrWatermark; Got %v", err)
+	}
+
+	if err := g.Check(PreendorsementWatermark, 100, 1); err != nil {
+		t.Errorf("Expecting kinds to be tracked separately: %s\n", err)
+	}
+}
+
+func benchmarkSignEndorsement(b *testing.B, singlePhase bool) {
+
+	requireDevice(b)
+
+	if err := tledger.SetBipPath(BENCH_BIP_PATH); err != nil {
+		b.Fatalf("Cannot set bip path: %s\n", err)
+	}
+
+	tledger.SinglePhaseSigning = singlePhase
+	defer func() {
+		tledger.SinglePhaseSigning = false
+	}()
+
+	// Each endorsement must be above the last to pass the device's watermark
+	level := make([]byte, 4)
+	for i := 0; i < b.N; i++ {
+
+		binary.BigEndian.PutUint32(level, uint32(BENCH_LEVEL + i))
+		endorsement := BENCH_BRANCH + "00" + hex.EncodeToString(level)
+
+		if _, err := tledger.SignEndorsement(endorsement, BENCH_CHAIN_ID); err != nil {
+			b.Fatalf("Cannot sign endorsement: %s\n", err)
+		}
+	}
+}
+
+func BenchmarkSignEndorsementTwoPhase(b *testing.B) {
+	benchmarkSignEndorsement(b, false)
+}
+
+func BenchmarkSignEndorsementSinglePhase(b *testing.B) {
+	benchmarkSignEndorsement(b, true)
+}
+
+func TestOperationKindTags(t *testing.T) {
+
+	for kind, tag := range map[OperationKind]uint8{
+		OpEndorsement:           0,
+		OpSeedNonceRevelation:   1,
+		OpPreendorsement:        20,
+		OpTenderbakeEndorsement: 21,
+		OpReveal:                107,
+		OpTransaction:           108,
+		OpOrigination:           109,
+		OpDelegation:            110,
+	} {
+		if uint8(kind) != tag {
+			t.Errorf("Expecting tag %d; Got %d", tag, uint8(kind))
+		}
+	}
+}
+
+// secp256k1 generator point, ie: the public key of secret key 1
+const SECP256K1_G = "0479be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798" +
+	"483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"
+
+const SECP256R1_G = "046b17d1f2e12c4247f8bce6e563a440f277037d812deb33a0f4a13945d898c296" +
+	"4fe342e2fe1a7f9b8ee7eb4a7c0f9e162bce33576b315ececbb6406837bf51f5"
+
+func TestSecp256k1PublicKey(t *testing.T) {
+
+	uncompressed, _ := hex.DecodeString(SECP256K1_G)
+
+	compressed, err := compressPublicKey(uncompressed)
+	if err != nil {
+		t.Fatalf("Cannot compress key: %s\n", err)
+	}
+
+	expected := "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+	if hex.EncodeToString(compressed) != expected {
+		t.Errorf("Expecting %s; Got %x", expected, compressed)
+	}
+
+	pk, pkh, err := encodeDevicePublicKey(SECP256K1, uncompressed)
+	if err != nil {
+		t.Fatalf("Cannot encode key: %s\n", err)
+	}
+
+	if pk != "sppk7aEFdrScsCDxdaQ7Ev1JxpWZESrEK6UsWRhr79JfGKkPYGTsudN" {
+		t.Errorf("Expecting sppk7aEFdrScsCDxdaQ7Ev1JxpWZESrEK6UsWRhr79JfGKkPYGTsudN; Got %s", pk)
+	}
+
+	if pkh != "tz2BCeQSi5ETyKJsob61pWCoQvoGtsrJBEt2" {
+		t.Errorf("Expecting tz2BCeQSi5ETyKJsob61pWCoQvoGtsrJBEt2; Got %s", pkh)
+	}
+}
+
+func TestDetectPrefix(t *testing.T) {
+
+	for s, expected := range map[string]string{
+		"tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx":                   "tz1",
+		"KT1PWx2mnDueood7fEmfbBDKx1D9BAnnXitn":                   "KT1",
+		"edpkuBknW28nW72KG6RoHtYW7p12T6GKc7nAbwYX5m8Wd9sDVC9yav": "edpk",
+		"NetXdQprcVkpaWU":                                        "Net",
+	} {
+		name, ok := DetectPrefix(s)
+		if !ok || name != expected {
+			t.Errorf("Expecting %s for %s; Got %s", expected, s, name)
+		}
+	}
+
+	if _, ok := DetectPrefix("tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSy"); ok {
+		t.Errorf("Expecting invalid checksum to not be detected")
+	}
+}
+
+func TestDevicePublicKeyTags(t *testing.T) {
+
+	uncompressed, _ := hex.DecodeString(SECP256K1_G)
+	compressed, _ := compressPublicKey(uncompressed)
+	p256Key, _ := hex.DecodeString(SECP256R1_G)
+	edKey, _ := hex.DecodeString("02" + strings.Repeat("11", 32))
+
+	for _, c := range []struct {
+		curve Curve
+		key   []byte
+		ok    bool
+	}{
+		{ED25519, edKey, true},
+		{BIP32_ED25519, edKey, true},
+		{ED25519, uncompressed, false},
+		{SECP256K1, uncompressed, true},
+		{SECP256K1, compressed, true},
+		{SECP256R1, p256Key, true},
+		{SECP256R1, uncompressed, false},
+		{SECP256K1, append([]byte{0x05}, uncompressed[1:]...), false},
+	} {
+		_, _, err := encodeDevicePublicKey(c.curve, c.key)
+		if (err == nil) != c.ok {
+			t.Errorf("Curve %d, tag 0x%02x: expecting ok=%t; Got %v", c.curve, c.key[0], c.ok, err)
+		}
+	}
+
+	// Both secp forms produce the same key
+	pkU, _, _ := encodeDevicePublicKey(SECP256K1, uncompressed)
+	pkC, _, _ := encodeDevicePublicKey(SECP256K1, compressed)
+	if pkU != pkC {
+		t.Errorf("Expecting %s; Got %s", pkU, pkC)
+	}
+}
+
+// Answers every request with 0x9000, until the failAt'th write, which fails
+type failingDevice struct {
+	writes      int
+	failAt      int
+	nonBlocking bool
+	pending     []byte
+}
+
+func (d *failingDevice) Write(b []byte) (int, error) {
+
+	d.writes++
+	if d.writes == d.failAt {
+		return -1, errors.New("device unplugged")
+	}
+
+	d.pending = make([]byte, 64)
+	copy(d.pending, []byte{0x01, 0x01, 0x05, 0x00, 0x00, 0x00, 0x02, 0x90, 0x00})
+
+	return len(b), nil
+}
+
+func (d *failingDevice) Read(b []byte) (int, error) {
+
+	if d.pending == nil {
+		return 0, nil
+	}
+
+	n := copy(b, d.pending)
+	d.pending = nil
+
+	return n, nil
+}
+
+func (d *failingDevice) ReadTimeout(b []byte, timeout int) (int, error) {
+	return d.Read(b)
+}
+
+func (d *failingDevice) SetNonBlocking(nonBlocking bool) (int, error) {
+	d.nonBlocking = nonBlocking
+	return 0, nil
+}
+
+func (d *failingDevice) Close() error {
+	return nil
+}
+
+func TestSignRestoresNonBlocking(t *testing.T) {
+
+	dev := &failingDevice{failAt: 2, nonBlocking: true}
+
+	l := &TezosLedger{
+		Ledger: &ledger.Ledger{Dev: dev},
+	}
+
+	if err := l.SetBipPath(BENCH_BIP_PATH); err != nil {
+		t.Fatalf("Cannot set bip path: %s\n", err)
+	}
+
+	if _, err := l.SignBytes([]byte{0x03, 0x01}); err == nil {
+		t.Fatalf("Expecting error when writing the bytes to sign fails")
+	}
+
+	if !dev.nonBlocking {
+		t.Errorf("Expecting non-blocking mode to be restored after failed signature")
+	}
+}
+
+func TestSignDisableBlockingToggle(t *testing.T) {
+
+	// Starts in blocking mode, so that any toggle leaves it non-blocking
+	dev := &failingDevice{failAt: 2}
+
+	l := &TezosLedger{
+		Ledger: &ledger.Ledger{Dev: dev, DisableBlockingToggle: true},
+	}
+
+	if err := l.SetBipPath(BENCH_BIP_PATH); err != nil {
+		t.Fatalf("Cannot set bip path: %s\n", err)
+	}
+
+	if _, err := l.SignBytes([]byte{0x03, 0x01}); err == nil {
+		t.Fatalf("Expecting error when writing the bytes to sign fails")
+	}
+
+	if dev.nonBlocking {
+		t.Errorf("Expecting blocking mode to be left alone")
+	}
+}
+
+func TestDERToRawLowS(t *testing.T) {
+
+	r := strings.Repeat("11", 32)
+
+	// s = 2 is already low; n - 2 is high and must be normalized to 2
+	lowS := "02"
+	highS := "00fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd036413f"
+
+	expected := r + strings.Repeat("00", 31) + "02"
+
+	for name, der := range map[string]string{
+		"low-S":  "3025" + "0220" + r + "0201" + lowS,
+		"high-S": "3145" + "0220" + r + "0221" + highS,
+	} {
+
+		derBytes, _ := hex.DecodeString(der)
+
+		raw, err := derToRaw(derBytes, secp256k1N)
+		if err != nil {
+			t.Errorf("%s: Cannot convert: %s\n", name, err)
+			continue
+		}
+
+		if hex.EncodeToString(raw) != expected {
+			t.Errorf("%s: Expecting %s; Got %x", name, expected, raw)
+		}
+	}
+
+	if _, err := derToRaw([]byte{0x30, 0x00}, secp256k1N); err == nil {
+		t.Errorf("Expecting error for truncated signature")
+	}
+
+	// 33 byte integers without the leading 0x00, zero, and values above the order
+	for name, der := range map[string]string{
+		"33 byte r": "3046" + "0221" + "01" + r + "0201" + lowS,
+		"zero s":    "3025" + "0220" + r + "0201" + "00",
+		"r = n":     "3026" + "0221" + "00fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141" + "0201" + lowS,
+	} {
+
+		derBytes, _ := hex.DecodeString(der)
+
+		if _, err := derToRaw(derBytes, secp256k1N); err == nil {
+			t.Errorf("%s: Expecting error", name)
+		}
+	}
+}
+
+func TestDecodeSignaturePrefixes(t *testing.T) {
+
+	raw := strings.Repeat("ab", 64)
+	rawBytes, _ := hex.DecodeString(raw)
+
+	for _, curve := range []Curve{ED25519, SECP256R1} {
+
+		// p256 signatures are DER; wrap the raw r | s
+		sig := rawBytes
+		if curve == SECP256R1 {
+			sig, _ = hex.DecodeString("3044" + "0220" + raw[:64] + "0220" + raw[64:])
+		}
+
+		encoded, err := encodeDeviceSignature(curve, sig)
+		if err != nil {
+			t.Fatalf("Cannot encode signature: %s\n", err)
+		}
+
+		decoded, err := decodeSignature(encoded)
+		if err != nil {
+			t.Fatalf("Cannot decode %s: %s\n", encoded, err)
+		}
+
+		// abab... is above half the p256 order; expect it normalized
+		if curve == SECP256R1 {
+			if decoded[:64] != raw[:64] || decoded[64:] == raw[64:] {
+				t.Errorf("Expecting normalized S; Got %s", decoded)
+			}
+			continue
+		}
+
+		if decoded != raw {
+			t.Errorf("Expecting %s; Got %s", raw, decoded)
+		}
+	}
+}
+
+func TestGenericSignature(t *testing.T) {
+
+	sigBytes := make([]byte, 64)
+	for i := range sigBytes {
+		sigBytes[i] = byte(i)
+	}
+
+	edsig, _ := encodeDeviceSignature(ED25519, sigBytes)
+
+	generic, err := ToGenericSignature(edsig)
+	if err != nil {
+		t.Fatalf("Cannot convert to generic: %s\n", err)
+	}
+
+	if !strings.HasPrefix(generic, "sig") {
+		t.Errorf("Expecting sig...; Got %s", generic)
+	}
+
+	back, err := FromGenericSignature(generic, ED25519)
+	if err != nil {
+		t.Fatalf("Cannot convert from generic: %s\n", err)
+	}
+
+	if back != edsig {
+		t.Errorf("Expecting %s; Got %s", edsig, back)
+	}
+
+	if _, err := ToGenericSignature(generic); err == nil {
+		t.Errorf("Expecting error converting a generic signature")
+	}
+
+	if _, err := ToGenericSignature("tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb"); err == nil {
+		t.Errorf("Expecting error converting an address")
+	}
+}
+
+func TestParseWatermarks(t *testing.T) {
+
+	chainID, _ := hex.DecodeString("7a06a770")
+
+	legacy := []byte{0, 0, 0, 10, 0, 0, 0, 5}
+	legacy = append(legacy, chainID...)
+
+	wm, err := parseWatermarks(legacy)
+	if err != nil {
+		t.Fatalf("Cannot parse legacy watermarks: %s\n", err)
+	}
+
+	if wm.Main.Level != 10 || wm.Test.Level != 5 || wm.HasRound || wm.ChainID != BENCH_CHAIN_ID {
+		t.Errorf("Unexpected legacy watermarks: %+v", wm)
+	}
+
+	tenderbake := []byte{0, 0, 0, 10, 0, 0, 0, 2, 0, 0, 0, 5, 0, 0, 0, 1}
+	tenderbake = append(tenderbake, chainID...)
+
+	wm, err = parseWatermarks(tenderbake)
+	if err != nil {
+		t.Fatalf("Cannot parse tenderbake watermarks: %s\n", err)
+	}
+
+	expected := Watermarks{HighWatermark{10, 2}, HighWatermark{5, 1}, BENCH_CHAIN_ID, true}
+	if wm != expected {
+		t.Errorf("Expecting %+v; Got %+v", expected, wm)
+	}
+
+	if _, err := parseWatermarks(chainID); err == nil {
+		t.Errorf("Expecting error for short reply")
+	}
+}
+
+func TestStatusPartial(t *testing.T) {
+
+	// Only the version and commit hash were recorded
+	fixtures, err := ledger.LoadFixtures("testdata/session.json")
+	if err != nil {
+		t.Fatalf("Cannot load fixtures: %s\n", err)
+	}
+
+	l := &TezosLedger{
+		Ledger: &ledger.Ledger{Dev: ledger.NewReplayer(fixtures)},
+	}
+
+	status, err := l.Status()
+	if err == nil {
+		t.Fatalf("Expecting error for incomplete status")
+	}
+
+	if status.Version != "Baking "+CUR_VER || status.CommitHash != CUR_HASH {
+		t.Errorf("Expecting version and commit hash; Got %+v", status)
+	}
+
+	if _, ok := status.Errors["AuthorizedKeyPath"]; !ok {
+		t.Errorf("Expecting AuthorizedKeyPath failure; Got %v", status.Errors)
+	}
+
+	if _, ok := status.Errors["Watermarks"]; !ok {
+		t.Errorf("Expecting Watermarks failure; Got %v", status.Errors)
+	}
+
+	if len(status.Errors) != 2 {
+		t.Errorf("Expecting 2 failures; Got %v", status.Errors)
+	}
+}
+
+func TestParseAuthorizedKey(t *testing.T) {
+
+	pathOnly := []byte{4, 128, 0, 0, 44, 128, 0, 6, 193, 128, 0, 0, 0, 128, 0, 0, 0}
+	withCurve := append([]byte{byte(SECP256K1)}, pathOnly...)
+
+	path, curve, err := parseAuthorizedKey(pathOnly)
+	if err != nil || path != BENCH_BIP_PATH || curve != ED25519 {
+		t.Errorf("Expecting %s; Got %s %d %v", BENCH_BIP_PATH, path, curve, err)
+	}
+
+	path, curve, err = parseAuthorizedKey(withCurve)
+	if err != nil || path != BENCH_BIP_PATH || curve != SECP256K1 {
+		t.Errorf("Expecting %s with curve; Got %s %d %v", BENCH_BIP_PATH, path, curve, err)
+	}
+
+	if _, _, err := parseAuthorizedKey(pathOnly[:10]); err == nil {
+		t.Errorf("Expecting error for truncated path")
+	}
+}
+
+func TestUnforgeOperation(t *testing.T) {
+
+	op, err := UnforgeOperation(BENCH_TX_HEX)
+	if err != nil {
+		t.Fatalf("Cannot unforge transaction: %s\n", err)
+	}
+
+	branchBytes, err := forgeBranch(op.Branch)
+	if err != nil || hex.EncodeToString(branchBytes) != BENCH_BRANCH {
+		t.Errorf("Expecting branch %s; Got %s (%v)", BENCH_BRANCH, op.Branch, err)
+	}
+
+	expected := Content{
+		Kind:         OpTransaction,
+		Source:       "tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx",
+		Fee:          1000,
+		Counter:      1,
+		GasLimit:     10,
+		StorageLimit: 0,
+		Amount:       1000000,
+		Destination:  "tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx",
+	}
+
+	if len(op.Contents) != 1 || op.Contents[0] != expected {
+		t.Errorf("Expecting %+v; Got %+v", expected, op.Contents)
+	}
+
+	// Round trip a forged delegation
+	delegation, _ := ForgeDelegation(expected.Source, expected.Source, 1257, 1, 10000, 0)
+
+	op, err = UnforgeOperation(BENCH_BRANCH + delegation)
+	if err != nil {
+		t.Fatalf("Cannot unforge delegation: %s\n", err)
+	}
+
+	if len(op.Contents) != 1 || op.Contents[0].Delegate != expected.Source || op.Contents[0].Fee != 1257 {
+		t.Errorf("Unexpected delegation %+v", op.Contents)
+	}
+
+	if _, err := UnforgeOperation(BENCH_TX_HEX[:100]); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expecting ErrTruncated; Got %v", err)
+	}
+}
+
+func TestOperationJSON(t *testing.T) {
+
+	op, err := UnforgeOperation(BENCH_TX_HEX)
+	if err != nil {
+		t.Fatalf("Cannot unforge transaction: %s\n", err)
+	}
+
+	b, err := json.Marshal(op)
+	if err != nil {
+		t.Fatalf("Cannot marshal: %s\n", err)
+	}
+
+	expected := `{"branch":"` + op.Branch + `","contents":[{"amount":"1000000","counter":"1",` +
+		`"destination":"tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx","fee":"1000","gas_limit":"10",` +
+		`"kind":"transaction","source":"tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx","storage_limit":"0"}]}`
+
+	if string(b) != expected {
+		t.Errorf("Expecting %s; Got %s", expected, string(b))
+	}
+}
+
+func TestOperationString(t *testing.T) {
+
+	op, err := UnforgeOperation(BENCH_TX_HEX)
+	if err != nil {
+		t.Fatalf("Cannot unforge transaction: %s\n", err)
+	}
+
+	expected := op.Branch + ": transaction of 1000000 mutez from tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx" +
+		" to tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx, fee 1000 mutez"
+
+	if op.String() != expected {
+		t.Errorf("Expecting %s; Got %s", expected, op.String())
+	}
+}
+
+// Helper function returning a ledger replaying single frame exchanges, given as
+// marshaled APDU requests and their responses including status word
+func replayLedger(t *testing.T, exchanges ...[2][]byte) *TezosLedger {
+
+	frame := func(prefix, payload []byte) string {
+
+		if len(payload) > 57 {
+			t.Fatalf("Payload too long for a single frame")
+		}
+
+		b := make([]byte, 64)
+		copy(b, []byte{0x01, 0x01, 0x05, 0x00, 0x00, 0x00, byte(len(payload))})
+		copy(b[7:], payload)
+
+		return hex.EncodeToString(append(prefix, b...))
+	}
+
+	f := &ledger.Fixtures{
+		Exchanges: make(map[string][]*ledger.Exchange),
+	}
+
+	for _, ex := range exchanges {
+
+		key := fmt.Sprintf("%02x", ex[0][1])
+		f.Exchanges[key] = append(f.Exchanges[key], &ledger.Exchange{
+			Request:  frame([]byte{0x00}, ex[0]),
+			Response: []string{frame(nil, ex[1])},
+		})
+	}
+
+	return &TezosLedger{
+		Ledger: &ledger.Ledger{Dev: ledger.NewReplayer(f)},
+	}
+}
+
+func TestSignBlockChainMismatch(t *testing.T) {
+
+	// Block header at level 2; fitness is empty
+	header := hex.EncodeToString([]byte{0, 0, 0, 2}) + strings.Repeat("00", 1+32+8+1+32+4)
+
+	// Main chain NetXdQprcVkpaWU
+	setup := [2][]byte{
+		{CLA, GetBakingHLW, 0x00, 0x00, 0x00},
+		{0, 0, 0, 1, 0, 0, 0, 0, 0x7a, 0x06, 0xa7, 0x70, 0x90, 0x00},
+	}
+
+	l := replayLedger(t, setup)
+	l.CheckChainID = true
+
+	// Ghostnet
+	if _, err := l.SignBlock(header, "NetXnHfVqm9iesp"); !errors.Is(err, ErrChainMismatch) {
+		t.Errorf("Expecting ErrChainMismatch; Got %v", err)
+	}
+
+	l = replayLedger(t, setup)
+	l.CheckChainID = true
+
+	if err := l.checkMainChain(header, BENCH_CHAIN_ID); err != nil {
+		t.Errorf("Expecting main chain to match; Got %v", err)
+	}
+}
+
+func TestEraWatermarks(t *testing.T) {
+
+	path, _ := hex.DecodeString("048000002c800006c18000000080000000")
+	ok := []byte{0x90, 0x00}
+	der := []byte{0x30, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01, 0x01}
+	chain := []byte{0x7a, 0x06, 0xa7, 0x70}
+
+	tests := []struct {
+		hlw         []byte
+		era         ConsensusEra
+		block       byte
+		endorsement byte
+	}{
+		// main level, test level, chain id
+		{[]byte{0, 0, 0, 1, 0, 0, 0, 0, 0x7a, 0x06, 0xa7, 0x70}, Emmy, 0x01, 0x02},
+
+		// main level and round, test level and round, chain id
+		{[]byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x7a, 0x06, 0xa7, 0x70}, Tenderbake, 0x11, 0x13},
+	}
+
+	for _, tt := range tests {
+
+		// Watermark, chain id, then the operation
+		signed := func(watermark byte, op ...byte) [][2][]byte {
+
+			data := append(append([]byte{watermark}, chain...), op...)
+
+			return [][2][]byte{
+				{append([]byte{CLA, SignBytes, P1First, byte(SECP256K1), byte(len(path))}, path...), ok},
+				{append([]byte{CLA, SignBytes, P1Last, 0x00, byte(len(data))}, data...), append(der, ok...)},
+			}
+		}
+
+		exchanges := [][2][]byte{{{CLA, GetBakingHLW, 0x00, 0x00, 0x00}, append(tt.hlw, ok...)}}
+		exchanges = append(exchanges, signed(tt.block, 0x00, 0x00, 0x00, 0x02, 0x01)...)
+		exchanges = append(exchanges, signed(tt.endorsement, 0xaa, 0xbb)...)
+
+		l := replayLedger(t, exchanges...)
+
+		if err := l.SetBipPathWithCurve(BENCH_BIP_PATH, SECP256K1); err != nil {
+			t.Fatalf("Cannot set bip path: %s\n", err)
+		}
+
+		if era, err := l.DetectEra(); err != nil || era != tt.era || l.Era != tt.era {
+			t.Fatalf("Expecting era %d; Got %d (%v)", tt.era, era, err)
+		}
+
+		// The replayer refuses any request other than the expected watermark
+		if _, err := l.SignBlock("0000000201", BENCH_CHAIN_ID); err != nil {
+			t.Errorf("Expecting block watermark 0x%02x; Got %v", tt.block, err)
+		}
+
+		if _, err := l.SignEndorsement("aabb", BENCH_CHAIN_ID); err != nil {
+			t.Errorf("Expecting endorsement watermark 0x%02x; Got %v", tt.endorsement, err)
+		}
+	}
+}
+
+func TestSendChunks(t *testing.T) {
+
+	data := make([]byte, 25)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	ok := []byte{0x90, 0x00}
+
+	// Chunks of 10, 10, and 5 bytes; only the last is marked P1Last
+	l := replayLedger(t,
+		[2][]byte{append([]byte{CLA, SignBytes, P1Other, 0x00, 10}, data[:10]...), ok},
+		[2][]byte{append([]byte{CLA, SignBytes, P1Other, 0x00, 10}, data[10:20]...), ok},
+		[2][]byte{append([]byte{CLA, SignBytes, P1Last, 0x00, 5}, data[20:]...), {0xaa, 0xbb, 0x90, 0x00}},
+	)
+
+	resp, err := l.sendChunks(SignBytes, 0x00, data, 10)
+	if err != nil {
+		t.Fatalf("Cannot send chunks: %s\n", err)
+	}
+
+	if !bytes.Equal(resp, []byte{0xaa, 0xbb}) {
+		t.Errorf("Expecting response aabb; Got %x", resp)
+	}
+}
+
+func TestEncodeContract(t *testing.T) {
+
+	hash := make([]byte, 20)
+	for i := range hash {
+		hash[i] = byte(i + 1)
+	}
+
+	addresses := []struct {
+		prefix []byte
+		tag    []byte
+	}{
+		{tz1prefix, []byte{0x00, 0x00}},
+		{tz2prefix, []byte{0x00, 0x01}},
+		{tz3prefix, []byte{0x00, 0x02}},
+		{ktprefix, []byte{0x01}},
+	}
+
+	for _, a := range addresses {
+
+		address := ledger.B58cencode(hash, a.prefix)
+
+		b, err := EncodeContract(address)
+		if err != nil {
+			t.Fatalf("Cannot encode %s: %s\n", address, err)
+		}
+
+		if len(b) != 22 || !bytes.Equal(b[:len(a.tag)], a.tag) || !bytes.Equal(b[len(a.tag):len(a.tag)+20], hash) {
+			t.Errorf("Unexpected encoding of %s: %x", address, b)
+		}
+
+		decoded, err := (&unforger{b}).contractId()
+		if err != nil || decoded != address {
+			t.Errorf("Expecting %s; Got %s (%v)", address, decoded, err)
+		}
+	}
+
+	if _, err := EncodeContract("edpkInvalid"); !errors.Is(err, ErrNotImplicit) {
+		t.Errorf("Expecting ErrNotImplicit; Got %v", err)
+	}
+}
+
+func TestWatermarksAllow(t *testing.T) {
+
+	wm := Watermarks{Main: HighWatermark{100, 2}, HasRound: true}
+
+	tests := []struct {
+		kind    WatermarkKind
+		level   uint32
+		round   uint32
+		allowed bool
+	}{
+		{BlockWatermark, 101, 0, true},
+		{BlockWatermark, 100, 3, true},
+		{BlockWatermark, 100, 2, false},
+		{BlockWatermark, 100, 1, false},
+		{BlockWatermark, 99, 5, false},
+		{EndorsementWatermark, 100, 2, true},
+		{PreendorsementWatermark, 100, 2, true},
+		{EndorsementWatermark, 100, 1, false},
+	}
+
+	for _, tt := range tests {
+		if allowed := wm.Allows(tt.kind, tt.level, tt.round); allowed != tt.allowed {
+			t.Errorf("Kind %d at %d/%d: expecting %v; Got %v", tt.kind, tt.level, tt.round, tt.allowed, allowed)
+		}
+	}
+
+	// Without rounds, a later round at the same level is no different
+	legacy := Watermarks{Main: HighWatermark{100, 0}}
+	if legacy.Allows(BlockWatermark, 100, 3) {
+		t.Errorf("Expecting block at legacy watermark level to be refused")
+	}
+}
+
+func TestUncompressedPublicKey(t *testing.T) {
+
+	// Generator points
+	points := map[Curve]string{
+		SECP256K1: SECP256K1_G,
+		SECP256R1: SECP256R1_G,
+	}
+
+	for curve, point := range points {
+
+		uncompressed, _ := hex.DecodeString(point)
+		compressed, _ := compressPublicKey(uncompressed)
+
+		key, err := uncompressedPublicKey(curve, compressed)
+		if err != nil || !bytes.Equal(key, uncompressed) {
+			t.Errorf("Curve %d: expecting %x; Got %x (%v)", curve, uncompressed, key, err)
+		}
+
+		key, err = uncompressedPublicKey(curve, uncompressed)
+		if err != nil || !bytes.Equal(key, uncompressed) {
+			t.Errorf("Curve %d: expecting %x unchanged; Got %x (%v)", curve, uncompressed, key, err)
+		}
+	}
+
+	edpk := append([]byte{pkTagEven}, bytes.Repeat([]byte{0x11}, 32)...)
+	if key, err := uncompressedPublicKey(ED25519, edpk); err != nil || !bytes.Equal(key, edpk[1:]) {
+		t.Errorf("Expecting raw ed25519 key; Got %x (%v)", key, err)
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+
+	refusals := []error{
+		&ledger.StatusError{Status: 0x6985, Err: ledger.ErrUserDenied},
+		fmt.Errorf("wrapped: %w", &ledger.StatusError{Status: 0x6a80, Err: ledger.ErrBelowWatermark}),
+		&ErrWatermark{Kind: BlockWatermark, Level: 1, LastLevel: 2},
+		ledger.ErrAborted,
+		ErrChainMismatch,
+	}
+
+	for _, err := range refusals {
+		if isConnectionError(err) {
+			t.Errorf("Expecting %v not to be retried", err)
+		}
+	}
+
+	readErr := &ledger.TransportError{Op: "read", Err: errors.New("hid: read failed")}
+	if !isConnectionError(fmt.Errorf("Unable to read bytes signature: %w", readErr)) {
+		t.Errorf("Expecting a failed read to be retried")
+	}
+}
+
+func TestParseAppVersion(t *testing.T) {
+
+	v, err := parseAppVersion([]byte{0x01, 2, 2, 9})
+	if err != nil || v.String() != "Baking 2.2.9" || v.HasCapabilities {
+		t.Errorf("Unexpected legacy version %+v (%v)", v, err)
+	}
+
+	v, err = parseAppVersion([]byte{0x00, 3, 0, 1, byte(CapRoundWatermark)})
+	if err != nil || v.String() != "Wallet 3.0.1" || !v.HasCapabilities {
+		t.Fatalf("Unexpected version %+v (%v)", v, err)
+	}
+
+	if !v.Capabilities.Has(CapRoundWatermark) || v.Capabilities.Has(CapHMAC) {
+		t.Errorf("Unexpected capabilities %b", v.Capabilities)
+	}
+
+	if _, err := parseAppVersion([]byte{0x01, 2}); err == nil {
+		t.Errorf("Expecting error for short reply")
+	}
+}
+
+func TestPromptsForConsensus(t *testing.T) {
+
+	for class, prompts := range map[byte]bool{0x00: true, 0x01: false} {
+
+		l := replayLedger(t, [2][]byte{
+			{0x80, Version, 0x00, 0x00, 0x00},
+			{class, 2, 2, 9, 0x90, 0x00},
+		})
+
+		got, err := l.PromptsForConsensus()
+		if err != nil {
+			t.Fatalf("Cannot get version: %s\n", err)
+		}
+
+		if got != prompts {
+			t.Errorf("Class 0x%02x: Expecting prompts %t; Got %t", class, prompts, got)
+		}
+	}
+}
+
+func TestResetBakingHLW(t *testing.T) {
+
+	version := []byte{CLA, Version, 0x00, 0x00, 0x00}
+	ok := []byte{0x90, 0x00}
+
+	// Level 2000000 is sent alone, big endian, after checking the Baking app is open
+	l := replayLedger(t,
+		[2][]byte{version, {0x01, 2, 4, 0, 0x90, 0x00}},
+		[2][]byte{{CLA, ResetHLW, 0x00, 0x00, 0x04, 0x00, 0x1e, 0x84, 0x80}, ok},
+	)
+
+	if err := l.ResetBakingHLW(2000000); err != nil {
+		t.Errorf("Expecting nil; Got %v", err)
+	}
+
+	// The Wallet app has no watermarks
+	l = replayLedger(t, [2][]byte{version, {0x00, 2, 4, 0, 0x90, 0x00}})
+
+	if err := l.ResetBakingHLW(2000000); !errors.Is(err, ErrNotBakingApp) {
+		t.Errorf("Expecting ErrNotBakingApp; Got %v", err)
+	}
+
+	// Refused before any exchange
+	l = replayLedger(t)
+
+	if err := l.ResetBakingHLWRound(2000000, 1); !errors.Is(err, ErrResetRound) {
+		t.Errorf("Expecting ErrResetRound; Got %v", err)
+	}
+
+	if err := l.ResetBakingHLW(-1); !errors.Is(err, ErrNegative) {
+		t.Errorf("Expecting ErrNegative; Got %v", err)
+	}
+}
+
+func TestCheckSignedOperation(t *testing.T) {
+
+	op := BENCH_TX_HEX
+	sig := strings.Repeat("ab", SignatureLength(SECP256K1))
+
+	if err := CheckSignedOperation(op, op+sig, SECP256K1); err != nil {
+		t.Errorf("Expecting valid signed operation; Got %s", err)
+	}
+
+	// DER encoded signature appended as-is
+	if err := CheckSignedOperation(op, op+sig+"0102030405060708", SECP256K1); err == nil {
+		t.Errorf("Expecting error for long signature")
+	}
+
+	if err := CheckSignedOperation(op[2:], op+sig, ED25519); err == nil {
+		t.Errorf("Expecting error for mismatched operation")
+	}
+
+	if SignatureLength(Curve(0x09)) != 0 {
+		t.Errorf("Expecting no signature length for unknown curve")
+	}
+}
+
+func TestPreSign(t *testing.T) {
+
+	refused := errors.New("refused")
+
+	var seen string
+	l := &TezosLedger{
+		Ledger: &ledger.Ledger{BipPath: []byte{0x01}},
+		PreSign: func(opHex string) error {
+			seen = opHex
+			return refused
+		},
+	}
+
+	// The device is never reached
+	if _, err := l.SignTransaction(BENCH_TX_HEX); !errors.Is(err, refused) {
+		t.Errorf("Expecting PreSign error; Got %v", err)
+	}
+
+	if seen != "03"+BENCH_TX_HEX {
+		t.Errorf("Expecting watermarked operation; Got %s", seen)
+	}
+
+	// Nor from the other signing paths
+	txBytes, _ := hex.DecodeString(BENCH_TX_HEX)
+
+	seen = ""
+	if _, err := l.SignVerified(txBytes); !errors.Is(err, refused) || seen != "03"+BENCH_TX_HEX {
+		t.Errorf("SignVerified: expecting PreSign error; Got %v (%s)", err, seen)
+	}
+
+	session := &SignSession{l: l}
+
+	seen = ""
+	if _, err := session.Sign(BENCH_TX_HEX); !errors.Is(err, refused) || seen != "03"+BENCH_TX_HEX {
+		t.Errorf("SignSession.Sign: expecting PreSign error; Got %v (%s)", err, seen)
+	}
+}
+
+func TestBakingStatus(t *testing.T) {
+
+	hlw := [2][]byte{
+		{CLA, GetBakingHLW, 0x00, 0x00, 0x00},
+		{0, 0, 0, 9, 0, 0, 0, 0, 0x7a, 0x06, 0xa7, 0x70, 0x90, 0x00},
+	}
+	query := []byte{CLA, QueryBakingKey, 0x00, 0x00, 0x00}
+
+	// Curve, then an empty path
+	l := replayLedger(t, [2][]byte{query, {0x00, 0x00, 0x90, 0x00}}, hlw)
+
+	status, err := l.BakingStatus()
+	if err != nil || status.Authorized || status.Watermarks.Main.Level != 9 {
+		t.Errorf("Expecting unauthorized status; Got %+v (%v)", status, err)
+	}
+
+	path := []byte{0x01, 0x04, 0x80, 0x00, 0x00, 0x2c, 0x80, 0x00, 0x06, 0xc1, 0x80, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00}
+	l = replayLedger(t, [2][]byte{query, append(path, 0x90, 0x00)}, hlw)
+
+	status, err = l.BakingStatus()
+	if err != nil || !status.Authorized || status.Key.Path != BENCH_BIP_PATH || status.Key.Curve != SECP256K1 {
+		t.Errorf("Expecting authorized status; Got %+v (%v)", status, err)
+	}
+
+	if status.Watermarks.ChainID != BENCH_CHAIN_ID {
+		t.Errorf("Expecting chain %s; Got %s", BENCH_CHAIN_ID, status.Watermarks.ChainID)
+	}
+}
+
+func TestForgeTransaction(t *testing.T) {
+
+	source := "tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx"
+
+	forged, err := ForgeTransaction(source, source, 1000000, 1000, 1, 10, 0)
+	if err != nil {
+		t.Fatalf("Cannot forge transaction: %s\n", err)
+	}
+
+	if BENCH_BRANCH+forged != BENCH_TX_HEX {
+		t.Errorf("Expecting %s; Got %s", BENCH_TX_HEX, BENCH_BRANCH+forged)
+	}
+}
+
+func TestSignPayouts(t *testing.T) {
+
+	source := "tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx"
+	branchBytes, _ := hex.DecodeString(BENCH_BRANCH)
+	branch := ledger.B58cencode(branchBytes, branchprefix)
+
+	// Capture what would be signed, without a device
+	var signed string
+	l := &TezosLedger{
+		Ledger: &ledger.Ledger{BipPath: []byte{0x01}},
+		PreSign: func(opHex string) error {
+			signed = opHex
+			return errors.New("captured")
+		},
+	}
+
+	payouts := []Payout{
+		{Destination: source, Amount: 100},
+		{Destination: ledger.B58cencode(make([]byte, 20), ktprefix), Amount: 200, GasLimit: 5000},
+	}
+
+	l.SignPayouts(source, payouts, 41, branch, 500)
+
+	op, err := UnforgeOperation(strings.TrimPrefix(signed, "03"))
+	if err != nil {
+		t.Fatalf("Cannot unforge payouts: %s\n", err)
+	}
+
+	if len(op.Contents) != 2 {
+		t.Fatalf("Expecting 2 transactions; Got %d", len(op.Contents))
+	}
+
+	for i, c := range op.Contents {
+		if c.Counter != int64(41+i) || c.Fee != 500 || c.Amount != payouts[i].Amount || c.Destination != payouts[i].Destination {
+			t.Errorf("Unexpected payout %d: %+v", i, c)
+		}
+	}
+
+	if op.Contents[0].GasLimit != DefaultPayoutGasLimit || op.Contents[1].GasLimit != 5000 {
+		t.Errorf("Unexpected gas limits %d, %d", op.Contents[0].GasLimit, op.Contents[1].GasLimit)
+	}
+
+	if _, err := l.SignPayouts(source, nil, 41, branch, 500); !errors.Is(err, ErrNoPayouts) {
+		t.Errorf("Expecting ErrNoPayouts; Got %v", err)
+	}
+}
+
+func TestStrictCoinType(t *testing.T) {
+
+	l := &TezosLedger{
+		Ledger:         &ledger.Ledger{},
+		StrictCoinType: true,
+	}
+
+	if err := l.SetBipPath("/44'/60'/0'/0'"); err == nil {
+		t.Errorf("Expecting error for Ethereum coin type")
+	}
+
+	if err := l.SetBipPath(StandardPath(0, 1)); err != nil {
+		t.Errorf("Expecting standard path to pass; Got %s", err)
+	}
+}
+
+// Known base58check encodings of each prefix type. The tz1 and ed25519 entries
+// are the sandbox bootstrap1 and bootstrap2 accounts; the secp256k1 and P256 keys
+// use the transaction's digest as secret. Signatures are over BENCH_TX_HEX as a
+// generic operation, and are checked in TestSignatureVectors.
+var B58Vectors = []struct {
+	Hex     string
+	Prefix  []byte
+	Encoded string
+}{
+	{"02298c03ed7d454a101eb7022bc95f7e5f41ac78", tz1prefix, "tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx"},
+	{"e7670f32038107a59a2b9cfefae36ea21f5aa63c", tz1prefix, "tz1gjaF81ZRRvdzjobyfVNsAeSC6PScjfQwN"},
+	{"aac1f4c03f7818d7388440e02d8834ffd460ecec", tz2prefix, "tz2Pt7tFVjUtn3SzoYnArzXZPFxSL1PuEQ7h"},
+	{"550eb382728ff1022a51e9397ba9a5448f88a1ab", tz3prefix, "tz3U5nfRe761z9odSBA4wyANT6868kPVuypP"},
+	{"a3d0f58d8964bd1b37fb0a0c197b38cf46608d49", ktprefix, "KT1PWx2mnDueood7fEmfbBDKx1D9BAnnXitn"},
+	{"4798d2cc98473d7e250c898885718afd2e4efbcb1a1595ab9730761ed830de0f", edpkprefix, "edpkuBknW28nW72KG6RoHtYW7p12T6GKc7nAbwYX5m8Wd9sDVC9yav"},
+	{"2dc050925cf3a80c0d0fd4589e1d86e2a4e07118e29458a537ed6382cb697d97", edpkprefix, "edpktzNbDAUjUk697W7gYg2CRuBQjyPxbEg8dLccYYwKSKvkPvjtV9"},
+	{"029d9bad55a2bd54885ef072df0872210bd4f7704f9d765817fcd9a8260be6238b", sppkprefix, "sppk7aW3k4NtBzss1zyTVnQaYJQtuiJveivSZV34aTEZYs9o6tXJsQD"},
+	{"0275562cc2d6577f977725b764de4f1513c2696d684366cfa4e4d6a4ad797937cc", p2pkprefix, "p2pk65TqbMHj24tTfkR1hUiuEd9btKfhELM1XFnfCdaYDBtMoNVo5BZ"},
+	{"8500c86780141917fcd8ac6a54a43a9eeda1aba9d263ce5dec5a1d0e5df1e598", edsk2prefix, "edsk3gUfUPyBSfrS9CCgmCiQsTCHGkviBDusMxDJstFtojtc1zcpsh"},
+	{"67efba7224b17e5478a90ea238a63d8b80e86b18d3c6b41310d1c8913397c61282c375465f6d2af1786769be0290d804e18e4ba89525b73b55e327457681d502", edsigprefix, "edsigtmQSL96HHS1cJoCBBH3PSyGQ6F771JvbytXzjDXfTNwp83uDeJag2HKn16iYiXmvJ6huPwEMNse5N9ejoPjU9oa1XYsuGF"},
+	{"3665b1a3e61cb2951140718a0e4f4eca9d42c032d92ced151fe7b361fbd0bb787196a726febe85937d380f8da6940d02374035a7385f7b0a11740506dbe5e64d", spsigprefix, "spsig1CvjzTrCiHp1sBtLoZDcXy57fwUm5MUc1ubQE7Htf6uPLaNFDs2xjAMLc55V1v34fwpKUgiqiNSJQZKmVH1KxrTEac1K1q"},
+	{"d6ccb0e201b21aea11af840b0909c786f36de7fee6a98f03cf2cf3ac8961e87cc422771b130f80072bd42491e7da46ada7b967acbb3ec8c1e9c65389367786fd", p2sigprefix, "p2sigqQRhGQSpKbV9XRG9FTbDXDMSCzFHF8KYRUdSd8jyrzJ9T5bgxaesQAjsc11eukfZKhPxVzi3FRs2LZg3G8NLJpQmFHFzJ"},
+	{"67efba7224b17e5478a90ea238a63d8b80e86b18d3c6b41310d1c8913397c61282c375465f6d2af1786769be0290d804e18e4ba89525b73b55e327457681d502", sigprefix, "sigbayD5sP1VpaFcCt3FCCVfytKR2B2g41cCnFzsXgT2vrpDxd9QfawE5rxW6kd4bZrZEHURSzYFgJdMcY9FPVxkVuaaaDr3"},
+	{"8fcf233671b6a04fcf679d2a381c2544ea6c1ea29ba6157776ed8424c7ccd00b", branchprefix, "BLockGenesisGenesisGenesisGenesisGenesisf79b5d1CoW2"},
+	{"7a06a770", networkprefix, "NetXdQprcVkpaWU"},
+	{"9caecab9", networkprefix, "NetXjD3HPJJjmcd"},
+	{"af1864d9", networkprefix, "NetXnHfVqm9iesp"},
+}
+
+func TestB58Vectors(t *testing.T) {
+
+	for _, v := range B58Vectors {
+
+		b, _ := hex.DecodeString(v.Hex)
+
+		if encoded := ledger.B58cencode(b, v.Prefix); encoded != v.Encoded {
+			t.Errorf("Expecting %s; Got %s", v.Encoded, encoded)
+		}
+
+		if decoded := ledger.B58cdecode(v.Encoded, v.Prefix); !bytes.Equal(decoded, b) {
+			t.Errorf("Decoding %s: expecting %s; Got %x", v.Encoded, v.Hex, decoded)
+		}
+	}
+
+	// Each public key hashes to the address beside it
+	pairs := [][2]string{
+		{"edpkuBknW28nW72KG6RoHtYW7p12T6GKc7nAbwYX5m8Wd9sDVC9yav", "tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx"},
+		{"edpktzNbDAUjUk697W7gYg2CRuBQjyPxbEg8dLccYYwKSKvkPvjtV9", "tz1gjaF81ZRRvdzjobyfVNsAeSC6PScjfQwN"},
+		{"sppk7aW3k4NtBzss1zyTVnQaYJQtuiJveivSZV34aTEZYs9o6tXJsQD", "tz2Pt7tFVjUtn3SzoYnArzXZPFxSL1PuEQ7h"},
+		{"p2pk65TqbMHj24tTfkR1hUiuEd9btKfhELM1XFnfCdaYDBtMoNVo5BZ", "tz3U5nfRe761z9odSBA4wyANT6868kPVuypP"},
+	}
+
+	for _, p := range pairs {
+		if pkh, err := AddressFromPublicKey(p[0]); err != nil || pkh != p[1] {
+			t.Errorf("Expecting %s; Got %s (%v)", p[1], pkh, err)
+		}
+	}
+}
+
+func TestSignatureVectors(t *testing.T) {
+
+	msg, _ := hex.DecodeString("03" + BENCH_TX_HEX)
+	digest, _ := ledger.Blake2b(msg, 32)
+
+	// Bootstrap1's secret key derives its public key and signs deterministically
+	seed := ledger.B58cdecode("edsk3gUfUPyBSfrS9CCgmCiQsTCHGkviBDusMxDJstFtojtc1zcpsh", edsk2prefix)
+	sk := ed25519.NewKeyFromSeed(seed)
+
+	edpk := ledger.B58cdecode("edpkuBknW28nW72KG6RoHtYW7p12T6GKc7nAbwYX5m8Wd9sDVC9yav", edpkprefix)
+	if !bytes.Equal(sk.Public().(ed25519.PublicKey), edpk) {
+		t.Errorf("Expecting bootstrap1 secret key to derive %x", edpk)
+	}
+
+	edsig := ledger.B58cdecode("edsigtmQSL96HHS1cJoCBBH3PSyGQ6F771JvbytXzjDXfTNwp83uDeJag2HKn16iYiXmvJ6huPwEMNse5N9ejoPjU9oa1XYsuGF", edsigprefix)
+	if !bytes.Equal(ed25519.Sign(sk, digest), edsig) || !ed25519.Verify(edpk, digest, edsig) {
+		t.Errorf("Expecting edsig to be bootstrap1's signature of the transaction")
+	}
+
+	p2pk := ledger.B58cdecode("p2pk65TqbMHj24tTfkR1hUiuEd9btKfhELM1XFnfCdaYDBtMoNVo5BZ", p2pkprefix)
+	x, y := elliptic.UnmarshalCompressed(elliptic.P256(), p2pk)
+	if x == nil {
+		t.Fatalf("Cannot decompress %x\n", p2pk)
+	}
+
+	p2sig := ledger.B58cdecode("p2sigqQRhGQSpKbV9XRG9FTbDXDMSCzFHF8KYRUdSd8jyrzJ9T5bgxaesQAjsc11eukfZKhPxVzi3FRs2LZg3G8NLJpQmFHFzJ", p2sigprefix)
+	r, s := new(big.Int).SetBytes(p2sig[:32]), new(big.Int).SetBytes(p2sig[32:])
+	if !ecdsa.Verify(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, digest, r, s) {
+		t.Errorf("Expecting p2sig to verify against p2pk")
+	}
+}
+
+func TestP256PublicKey(t *testing.T) {
+
+	// Public key of the secret key 1; the generator point
+	uncompressed, _ := hex.DecodeString(SECP256R1_G)
+	compressed, _ := hex.DecodeString("036b17d1f2e12c4247f8bce6e563a440f277037d812deb33a0f4a13945d898c296")
+
+	const (
+		expectedPk  = "p2pk67L57Q7vcgLkMrKXctFRKs5JSLR6qjiw1riJaFyakWpTv9QSkRf"
+		expectedPkh = "tz3bqAfFRnSA6dfPRG8XR6MBMmo6HZTTG44V"
+	)
+
+	for _, key := range [][]byte{uncompressed, compressed} {
+
+		pk, pkh, err := encodeDevicePublicKey(SECP256R1, key)
+		if err != nil || pk != expectedPk || pkh != expectedPkh {
+			t.Errorf("Expecting %s %s; Got %s %s (%v)", expectedPk, expectedPkh, pk, pkh, err)
+		}
+	}
+
+	if pkh, err := AddressFromPublicKey(expectedPk); err != nil || pkh != expectedPkh {
+		t.Errorf("Expecting %s; Got %s (%v)", expectedPkh, pkh, err)
+	}
+
+	// Off the curve
+	corrupted := append([]byte(nil), uncompressed...)
+	corrupted[64] ^= 0x01
+
+	if _, _, err := encodeDevicePublicKey(SECP256R1, corrupted); err == nil {
+		t.Errorf("Expecting error for point not on the curve")
+	}
+}
+
+func TestLazyLedgerRemembersPath(t *testing.T) {
+
+	l := replayLedger(t)
+	if err := l.SetBipPathWithCurve(BENCH_BIP_PATH, SECP256K1); err != nil {
+		t.Fatalf("Cannot set bip path: %s\n", err)
+	}
+
+	z := NewLazyLedger(0)
+	z.l = l
+
+	if err := z.Close(); err != nil {
+		t.Fatalf("Cannot close: %s\n", err)
+	}
+
+	// Close() clears the connection's path, but not the one to re-apply
+	if l.BipPathString != "" || z.path != BENCH_BIP_PATH || z.curve != SECP256K1 {
+		t.Errorf("Expecting %s remembered; Got %q %d (connection %q)", BENCH_BIP_PATH, z.path, z.curve, l.BipPathString)
+	}
+}
+
+func TestNonceHash(t *testing.T) {
+
+	nonce := bytes.Repeat([]byte{0x01}, 32)
+
+	nonceHash, err := NonceHash(nonce)
+	if err != nil {
+		t.Fatalf("Cannot hash nonce: %s\n", err)
+	}
+
+	if name, ok := DetectPrefix(nonceHash); !ok || name != "nce" {
+		t.Errorf("Expecting nce prefix; Got %s (%t)", nonceHash, ok)
+	}
+
+	hash, _ := ledger.Blake2b(nonce, 32)
+	if !bytes.Equal(ledger.B58cdecode(nonceHash, noncehashprefix), hash) {
+		t.Errorf("Expecting %s to encode blake2b of nonce", nonceHash)
+	}
+
+	if _, err := NonceHash(nonce[:31]); !errors.Is(err, ErrInvalidNonce) {
+		t.Errorf("Expecting ErrInvalidNonce; Got %v", err)
+	}
+}
+
+func TestRevealNonce(t *testing.T) {
+
+	nonce := bytes.Repeat([]byte{0xab}, 32)
+
+	forged, err := ForgeNonceRevelation(0x012345, nonce)
+	if err != nil {
+		t.Fatalf("Cannot forge nonce revelation: %s\n", err)
+	}
+
+	expected := "0100012345" + strings.Repeat("ab", 32)
+	if forged != expected {
+		t.Errorf("Expecting %s; Got %s", expected, forged)
+	}
+
+	if _, err := ForgeNonceRevelation(1, nonce[:16]); !errors.Is(err, ErrInvalidNonce) {
+		t.Errorf("Expecting ErrInvalidNonce; Got %v", err)
+	}
+
+	if _, err := ForgeNonceRevelation(-1, nonce); !errors.Is(err, ErrNegative) {
+		t.Errorf("Expecting ErrNegative; Got %v", err)
+	}
+
+	branchBytes, _ := hex.DecodeString(BENCH_BRANCH)
+	branch := ledger.B58cencode(branchBytes, branchprefix)
+
+	// Capture what would be signed, without a device
+	var signed string
+	l := &TezosLedger{
+		Ledger: &ledger.Ledger{BipPath: []byte{0x01}},
+		PreSign: func(opHex string) error {
+			signed = opHex
+			return errors.New("captured")
+		},
+	}
+
+	l.RevealNonce(0x012345, nonce, branch, "")
+
+	if signed != "03" + BENCH_BRANCH + expected {
+		t.Errorf("Expecting %s signed; Got %s", "03" + BENCH_BRANCH + expected, signed)
+	}
+}
+
+func TestGetPublicKeyWithPromptContext(t *testing.T) {
+
+	l := &TezosLedger{
+		Ledger: &ledger.Ledger{Dev: silentDevice{}},
+	}
+
+	if err := l.SetBipPath(BENCH_BIP_PATH); err != nil {
+		t.Fatalf("Cannot set bip path: %s\n", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150 * time.Millisecond)
+	defer cancel()
+
+	if _, _, err := l.GetPublicKeyWithPromptContext(ctx); !errors.Is(err, ErrConfirmTimeout) {
+		t.Errorf("Expecting ErrConfirmTimeout; Got %v", err)
+	}
+}
+
+// Accepts every request, and never answers; ie: the user is never pressing a button
+type silentDevice struct {
+	ledger.HIDDevice
+}
+
+func (silentDevice) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (silentDevice) Read(b []byte) (int, error) {
+	return 0, nil
+}
+
+func (silentDevice) ReadTimeout(b []byte, timeout int) (int, error) {
+	return 0, nil
+}
+
+func TestParseSetupResponse(t *testing.T) {
+
+	key, _ := hex.DecodeString("02" + "4798d2cc98473d7e250c898885718afd2e4efbcb1a1595ab9730761ed830de0f")
+	chainID, _ := hex.DecodeString("7a06a770")
+
+	resp := append([]byte{byte(len(key))}, key...)
+
+	// Older apps reply with the key alone
+	res, err := parseSetupResponse(ED25519, resp)
+	if err != nil {
+		t.Fatalf("Cannot parse setup response: %s\n", err)
+	}
+
+	if res.PublicKey != "edpkuBknW28nW72KG6RoHtYW7p12T6GKc7nAbwYX5m8Wd9sDVC9yav" || res.HasWatermarks {
+		t.Errorf("Unexpected legacy setup result %+v", res)
+	}
+
+	echo := append([]byte{0, 0, 0, 10, 0, 0, 0, 10}, chainID...)
+
+	res, err = parseSetupResponse(ED25519, append(resp, echo...))
+	if err != nil {
+		t.Fatalf("Cannot parse setup response with echo: %s\n", err)
+	}
+
+	if !res.HasWatermarks || res.Watermarks.Main.Level != 10 || res.Watermarks.ChainID != BENCH_CHAIN_ID {
+		t.Errorf("Unexpected setup watermarks %+v", res.Watermarks)
+	}
+
+	if _, err := parseSetupResponse(ED25519, resp[:20]); !errors.Is(err, ErrLengthMismatch) {
+		t.Errorf("Expecting ErrLengthMismatch; Got %v", err)
+	}
+}
+
+func TestUnknownKeyTagWarns(t *testing.T) {
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	for curve, tags := range devicePublicKeyTags {
+		for _, tag := range tags {
+			if !knownKeyTag(curve, tag) {
+				t.Errorf("Expecting tag 0x%02x known for curve 0x%02x", tag, uint8(curve))
+			}
+		}
+	}
+
+	// Compressed secp tags are not used for ed25519
+	key := append([]byte{pkTagOdd}, bytes.Repeat([]byte{0x11}, 32)...)
+
+	if _, _, err := encodeDevicePublicKey(ED25519, key); err == nil {
+		t.Errorf("Expecting error for unknown ed25519 tag")
+	}
+
+	if !strings.Contains(buf.String(), "Unexpected public key format tag") {
+		t.Errorf("Expecting warning logged; Got %q", buf.String())
+	}
+}
+
+func TestAssembleInjection(t *testing.T) {
+
+	sigBytes := bytes.Repeat([]byte{0xab}, 64)
+
+	signer := func(b []byte) (string, error) {
+		return ledger.B58cencode(sigBytes, edsigprefix), nil
+	}
+
+	out, err := signOperation(signer, genericopprefix, BENCH_TX_HEX, "")
+	if err != nil {
+		t.Fatalf("Cannot sign operation: %s\n", err)
+	}
+
+	if injection := AssembleInjection(BENCH_TX_HEX, hex.EncodeToString(sigBytes)); injection != out.SignedOperation {
+		t.Errorf("Expecting %s; Got %s", out.SignedOperation, injection)
+	}
+}
+
+func TestSinglePhaseEmptyReplyFallsBack(t *testing.T) {
+
+	path, _ := hex.DecodeString("048000002c800006c18000000080000000")
+	data := []byte{0x03, 0x01}
+	ok := []byte{0x90, 0x00}
+
+	// secp256k1, DER r = 1, s = 1
+	der := []byte{0x30, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01, 0x01}
+
+	single := append([]byte{CLA, SignBytes, 0x80, byte(SECP256K1), byte(len(path) + len(data))}, path...)
+
+	// The app parses only the path of the single-phase request, and answers with
+	// an empty success; the two-phase exchange must follow
+	l := replayLedger(t,
+		[2][]byte{append(single, data...), ok},
+		[2][]byte{append([]byte{CLA, SignBytes, P1First, byte(SECP256K1), byte(len(path))}, path...), ok},
+		[2][]byte{append([]byte{CLA, SignBytes, P1Last, 0x00, byte(len(data))}, data...), append(der, ok...)},
+	)
+	l.SinglePhaseSigning = true
+
+	if err := l.SetBipPathWithCurve(BENCH_BIP_PATH, SECP256K1); err != nil {
+		t.Fatalf("Cannot set bip path: %s\n", err)
+	}
+
+	if !bytes.Equal(l.BipPath, path) {
+		t.Fatalf("Unexpected encoded path %x", l.BipPath)
+	}
+
+	sig, err := l.SignBytes(data)
+	if err != nil {
+		t.Fatalf("Cannot sign: %s\n", err)
+	}
+
+	raw := append(append(make([]byte, 31), 0x01), append(make([]byte, 31), 0x01)...)
+	if sig != ledger.B58cencode(raw, spsigprefix) {
+		t.Errorf("Unexpected signature %s", sig)
+	}
+
+	if !l.singlePhaseUnsupported {
+		t.Errorf("Expecting single-phase marked unsupported")
+	}
+
+	if _, err := encodeDeviceSignature(ED25519, nil); err == nil {
+		t.Errorf("Expecting error for empty ed25519 signature")
+	}
+}
+
+func TestSignSessionChunks(t *testing.T) {
+
+	path, _ := hex.DecodeString("048000002c800006c18000000080000000")
+	ok := []byte{0x90, 0x00}
+	der := []byte{0x30, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01, 0x01}
+
+	data := make([]byte, 15)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	l := replayLedger(t,
+		[2][]byte{append([]byte{CLA, SignBytes, P1First, byte(SECP256K1), byte(len(path))}, path...), ok},
+		[2][]byte{append([]byte{CLA, SignBytes, P1Other, 0x00, 10}, data[:10]...), ok},
+		[2][]byte{append([]byte{CLA, SignBytes, P1Last, 0x00, 5}, data[10:]...), append(der, ok...)},
+	)
+	l.Curve = SECP256K1
+
+	session, err := l.NewSignSession(BENCH_BIP_PATH)
+	if err != nil {
+		t.Fatalf("Cannot open session: %s\n", err)
+	}
+	defer session.Close()
+
+	sig, err := session.signChunks(data, 10)
+	if err != nil {
+		t.Fatalf("Cannot sign: %s\n", err)
+	}
+
+	raw := append(append(make([]byte, 31), 0x01), append(make([]byte, 31), 0x01)...)
+	if sig != ledger.B58cencode(raw, spsigprefix) {
+		t.Errorf("Unexpected signature %s", sig)
+	}
+}
+
+func TestScanAddressesInvalid(t *testing.T) {
+
+	// The device is never reached
+	l := &TezosLedger{Ledger: &ledger.Ledger{}}
+
+	for _, c := range []struct{ account, count int64 }{
+		{-1, 1},
+		{ledger.HARDENED, 1},
+		{0, -1},
+	} {
+		if _, err := l.ScanAddresses(int(c.account), int(c.count)); err == nil {
+			t.Errorf("Expecting error for account %d, count %d", c.account, c.count)
+		}
+	}
+}
+
+func TestPingErrors(t *testing.T) {
+
+	version := []byte{CLA, Version, 0x00, 0x00, 0x00}
+
+	// Device statuses are returned as they are
+	for status, sentinel := range map[uint16]error{
+		0x6e00: ledger.ErrWrongApp,
+		0x5515: ledger.ErrDeviceLocked,
+	} {
+
+		l := replayLedger(t, [2][]byte{version, {byte(status >> 8), byte(status)}})
+
+		err := l.Ping()
+
+		var statusErr *ledger.StatusError
+		if !errors.As(err, &statusErr) || !errors.Is(err, sentinel) || errors.Is(err, ErrNoResponse) {
+			t.Errorf("Status 0x%04x: expecting %v; Got %v", status, sentinel, err)
+		}
+	}
+
+	// Transport failures are ErrNoResponse, keeping the cause
+	dev := &failingDevice{failAt: 1}
+	l := &TezosLedger{Ledger: &ledger.Ledger{Dev: dev}}
+
+	err := l.Ping()
+
+	var transportErr *ledger.TransportError
+	if !errors.Is(err, ErrNoResponse) || !errors.As(err, &transportErr) {
+		t.Errorf("Expecting ErrNoResponse with TransportError; Got %v", err)
+	}
+
+	if err := replayLedger(t, [2][]byte{version, {0x01, 2, 2, 9, 0x90, 0x00}}).Ping(); err != nil {
+		t.Errorf("Expecting nil; Got %v", err)
+	}
+}
+
+func TestCounterManager(t *testing.T) {
+
+	c := NewCounterManager(7)
+
+	// Each step is an operation, and the counter it returns
+	steps := []struct {
+		op       string
+		arg      int64
+		expected int64
+	}{
+		{"peek", 0, 7},
+		{"next", 0, 7},
+		{"next", 0, 8},
+		{"peek", 0, 9},
+		{"peek", 0, 9},
+		{"next", 0, 9},
+		{"reset", 20, 20},
+		{"next", 0, 20},
+		{"reset", 5, 5},
+		{"next", 0, 5},
+		{"next", 0, 6},
+	}
+
+	for i, s := range steps {
+
+		var got int64
+
+		switch s.op {
+		case "next":
+			got = c.Next()
+		case "peek":
+			got = c.Peek()
+		case "reset":
+			c.Reset(s.arg)
+			got = c.Peek()
+		}
+
+		if got != s.expected {
+			t.Errorf("#%d %s: Expecting %d; Got %d", i, s.op, s.expected, got)
+		}
+	}
+}
+
+func TestCounterManagerConcurrent(t *testing.T) {
+
+	const (
+		start      = 100
+		goroutines = 20
+		perRoutine = 250
+	)
+
+	c := NewCounterManager(start)
+
+	counters := make(chan int64, goroutines*perRoutine)
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perRoutine; i++ {
+				counters <- c.Next()
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(counters)
+
+	// Every counter is handed out exactly once, without gaps
+	seen := make(map[int64]bool)
+	for n := range counters {
+
+		if seen[n] {
+			t.Errorf("Counter %d handed out twice", n)
+		}
+		seen[n] = true
+
+		if n < start || n >= start+goroutines*perRoutine {
+			t.Errorf("Counter %d out of range", n)
+		}
+	}
+
+	if len(seen) != goroutines*perRoutine {
+		t.Errorf("Expecting %d counters; Got %d", goroutines*perRoutine, len(seen))
+	}
+
+	if c.Peek() != start+goroutines*perRoutine {
+		t.Errorf("Expecting next counter %d; Got %d", start+goroutines*perRoutine, c.Peek())
+	}
+}
+
+func TestSplitSignedOperation(t *testing.T) {
+
+	// Bootstrap1's signature of BENCH_TX_HEX; see TestSignatureVectors
+	const edsig = "edsigtmQSL96HHS1cJoCBBH3PSyGQ6F771JvbytXzjDXfTNwp83uDeJag2HKn16iYiXmvJ6huPwEMNse5N9ejoPjU9oa1XYsuGF"
+
+	signer := func([]byte) (string, error) {
+		return edsig, nil
+	}
+
+	out, err := signOperation(signer, genericopprefix, BENCH_TX_HEX, "")
+	if err != nil {
+		t.Fatalf("Cannot sign operation: %s\n", err)
+	}
+
+	opHex, sigHex, err := SplitSignedOperation(out.SignedOperation)
+	if err != nil {
+		t.Fatalf("Cannot split signed operation: %s\n", err)
+	}
+
+	if opHex != BENCH_TX_HEX || sigHex != out.Signature {
+		t.Errorf("Expecting %s | %s; Got %s | %s", BENCH_TX_HEX, out.Signature, opHex, sigHex)
+	}
+
+	// A signature alone, or less, leaves no operation; neither does invalid hex
+	for _, signed := range []string{"", "aabb", out.Signature, "zz" + out.Signature} {
+		if _, _, err := SplitSignedOperation(signed); err == nil {
+			t.Errorf("Expecting error splitting %q", signed)
+		}
+	}
+}
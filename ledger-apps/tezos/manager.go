@@ -0,0 +1,104 @@
+package tezos
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrAliasInUse   = errors.New("Alias is already open")
+	ErrUnknownAlias = errors.New("No device open under alias")
+)
+
+// DeviceManager holds several open devices under operator chosen aliases, such as
+// one for consensus keys and another for payouts. Each device keeps its own bip
+// path and settings. A DeviceManager is safe for concurrent use, though each
+// TezosLedger it returns is not.
+type DeviceManager struct {
+	mu      sync.Mutex
+	devices map[string]*TezosLedger
+}
+
+// Returns an empty manager
+func NewDeviceManager() *DeviceManager {
+	return &DeviceManager{
+		devices: make(map[string]*TezosLedger),
+	}
+}
+
+// Opens the device with the given serial number and holds it under alias. Options
+// may be given to override the default USB identifiers.
+// Returns the opened device, or error
+func (m *DeviceManager) Open(alias, serial string, opts ...Option) (*TezosLedger, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.devices[alias]; ok {
+		return nil, errors.Wrap(ErrAliasInUse, alias)
+	}
+
+	l, err := Get(append(opts, WithSerial(serial))...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to open %s (%s)", alias, serial)
+	}
+
+	m.devices[alias] = l
+
+	return l, nil
+}
+
+// Returns the device held under alias, or ErrUnknownAlias
+func (m *DeviceManager) Get(alias string) (*TezosLedger, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, ok := m.devices[alias]
+	if !ok {
+		return nil, errors.Wrap(ErrUnknownAlias, alias)
+	}
+
+	return l, nil
+}
+
+// Closes the device held under alias, and forgets it
+func (m *DeviceManager) Close(alias string) error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, ok := m.devices[alias]
+	if !ok {
+		return errors.Wrap(ErrUnknownAlias, alias)
+	}
+
+	delete(m.devices, alias)
+
+	return l.Close()
+}
+
+// Closes every device, and forgets them. All devices are closed even if
+// some fail; the failures are returned together.
+func (m *DeviceManager) CloseAll() error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var failed []string
+
+	for alias, l := range m.devices {
+		if err := l.Close(); err != nil {
+			failed = append(failed, alias + ": " + err.Error())
+		}
+		delete(m.devices, alias)
+	}
+
+	if len(failed) > 0 {
+		return errors.Errorf("Unable to close devices; %s", strings.Join(failed, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package tezos
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Gas limit of a payout which does not set one; enough for a transfer
+	// to an implicit account
+	DefaultPayoutGasLimit int64 = 1520
+
+	// Storage limit of a payout which does not set one; enough to allocate
+	// the destination, if it is an empty implicit account
+	DefaultPayoutStorageLimit int64 = 257
+)
+
+var ErrNoPayouts = errors.New("No payouts to sign")
+
+// Payout is a single transfer of Amount mutez to Destination within a batch.
+// Zero limits are replaced by DefaultPayoutGasLimit and DefaultPayoutStorageLimit.
+type Payout struct {
+	Destination  string
+	Amount       int64
+	GasLimit     int64
+	StorageLimit int64
+}
+
+// Forges a transaction from source for each payout, assigning counters in order from
+// startCounter and charging each baseFee, then signs them as a single operation group
+// against branch. Use SetBipPath() before calling this function.
+// Returns signed operation, or error
+func (t *TezosLedger) SignPayouts(source string, payouts []Payout, startCounter int64, branch string, baseFee int64) (SignOperationOutput, error) {
+
+	if len(payouts) == 0 {
+		return SignOperationOutput{}, ErrNoPayouts
+	}
+
+	counters := NewCounterManager(startCounter)
+
+	var contents strings.Builder
+
+	for i, p := range payouts {
+
+		gas, storage := p.GasLimit, p.StorageLimit
+		if gas == 0 {
+			gas = DefaultPayoutGasLimit
+		}
+		if storage == 0 {
+			storage = DefaultPayoutStorageLimit
+		}
+
+		forged, err := ForgeTransaction(source, p.Destination, p.Amount, baseFee, counters.Next(), gas, storage)
+		if err != nil {
+			return SignOperationOutput{}, errors.Wrapf(err, "Unable to forge payout %d", i)
+		}
+
+		contents.WriteString(forged)
+	}
+
+	return t.SignWithBranch(branch, contents.String())
+}
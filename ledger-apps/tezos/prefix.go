@@ -0,0 +1,74 @@
+package tezos
+
+import (
+	"bytes"
+
+	goledger "github.com/bakingbacon/goledger"
+)
+
+// Entry in the prefix registry; the base58check prefix, and the
+// length of the payload which follows it
+type prefixEntry struct {
+	name   string
+	prefix goledger.Prefix
+	length int
+}
+
+// Registry of known prefixes, keyed by the human readable
+// start of the base58check strings they produce
+var prefixRegistry = []prefixEntry{
+	{"tz1", tz1prefix, 20},
+	{"tz2", tz2prefix, 20},
+	{"tz3", tz3prefix, 20},
+	{"KT1", ktprefix, 20},
+
+	{"edpk", edpkprefix, 32},
+	{"sppk", sppkprefix, 33},
+	{"p2pk", p2pkprefix, 33},
+
+	{"edsk", edsk2prefix, 32}, // seed
+	{"edsk64", edskprefix, 64}, // seed + public key, also rendered as edsk...
+	{"edesk", edeskprefix, 56},
+
+	{"edsig", edsigprefix, 64},
+	{"spsig1", spsigprefix, 64},
+	{"p2sig", p2sigprefix, 64},
+	{"sig", sigprefix, 64},
+
+	{"B", branchprefix, 32},
+	{"o", operationprefix, 32},
+	{"nce", noncehashprefix, 32},
+	{"Net", networkprefix, 4},
+}
+
+// Returns the prefix registered under name (ie: "tz1", "edpk", "edsig")
+func PrefixFor(name string) (goledger.Prefix, bool) {
+
+	for _, e := range prefixRegistry {
+		if e.name == name {
+			return e.prefix, true
+		}
+	}
+
+	return nil, false
+}
+
+// Identifies what a base58check encoded string is by its prefix and payload length
+// Returns the registered name (ie: "tz1", "edpk", "edsig"), or false if the string
+// is invalid or its prefix is not registered
+func DetectPrefix(s string) (string, bool) {
+
+	if err := goledger.ValidateB58Check(s); err != nil {
+		return "", false
+	}
+
+	decoded := goledger.B58cdecode(s, nil)
+
+	for _, e := range prefixRegistry {
+		if bytes.HasPrefix(decoded, e.prefix) && len(decoded)-len(e.prefix) == e.length {
+			return e.name, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,134 @@
+package tezos
+
+import (
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrSessionClosed = errors.New("Signing session is closed")
+)
+
+// SignSession holds exclusive use of the device for signing many generic operations
+// back-to-back, such as during a payout run. The BIP path is set once when the
+// session is opened, and the device is kept in blocking mode for the lifetime of
+// the session rather than toggled for every signature.
+//
+// A SignSession is not reentrant and must not be shared between goroutines. While
+// a session is open, no other functions should be called on the TezosLedger.
+//
+// The device will still prompt the user to confirm each operation unless the
+// open app is configured to allow them without confirmation.
+type SignSession struct {
+	l *TezosLedger
+
+	// Re-used for every signature; only CDATA of opApdu changes
+	pathApdu *TzApdu
+	opApdu   *TzApdu
+
+	closed bool
+}
+
+// Opens a signing session using the given bip path. Blocks until any other
+// session on this device has been closed.
+// Returns the session, or error
+func (l *TezosLedger) NewSignSession(bipPath string) (*SignSession, error) {
+
+	l.session.Lock()
+
+	if err := l.SetBipPath(bipPath); err != nil {
+		l.session.Unlock()
+		return nil, err
+	}
+
+	if r, err := l.SetNonBlocking(false); r == -1 {
+		l.session.Unlock()
+		return nil, errors.Wrap(err, "Could not set blocking")
+	}
+
+	return &SignSession{
+		l: l,
+		pathApdu: &TzApdu{
+			SignBytes,
+			0x00,
+			byte(l.Curve),
+			l.BipPath,
+		},
+		opApdu: &TzApdu{
+			SignBytes,
+			0x81,
+			0x00,
+			nil,
+		},
+	}, nil
+}
+
+// Signs a generic operation (ie: transaction, reveal, delegation) given as hex
+// Returns the signed operation, or error
+func (s *SignSession) Sign(opHex string) (SignOperationOutput, error) {
+
+	if s.closed {
+		return SignOperationOutput{}, ErrSessionClosed
+	}
+
+	return signOperation(s.l.presignWrap(s.signBytes), genericopprefix, opHex, "")
+}
+
+// Ends the session, restoring non-blocking mode and releasing the device
+func (s *SignSession) Close() error {
+
+	if s.closed {
+		return ErrSessionClosed
+	}
+	s.closed = true
+
+	defer s.l.session.Unlock()
+
+	if r, err := s.l.SetNonBlocking(true); r == -1 {
+		return errors.Wrap(err, "Could not set non-blocking")
+	}
+
+	return nil
+}
+
+// Same exchange as TezosLedger.SignBytes without re-checking the bip path or
+// toggling the blocking mode of the device
+func (s *SignSession) signBytes(bytesToSign []byte) (string, error) {
+	return s.signChunks(bytesToSign, maxCDataLen)
+}
+
+// Helper function for signBytes() splitting bytesToSign into chunks of at most size
+// bytes, as TezosLedger.SendChunks() does
+func (s *SignSession) signChunks(bytesToSign []byte, size int) (string, error) {
+
+	s.l.BeginMultiPart()
+	defer s.l.EndMultiPart()
+
+	_, err := s.l.Write(s.pathApdu, TEZOS_CHANNEL)
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to sign bytes (1)")
+	}
+
+	_, err = s.l.Read(TEZOS_CHANNEL)
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to read bytes signature (1)")
+	}
+
+	last, err := s.l.writeLeadingChunks(SignBytes, 0x00, bytesToSign, size)
+	if err != nil {
+		return "", err
+	}
+
+	s.opApdu.CDATA = last
+
+	_, err = s.l.Write(s.opApdu, TEZOS_CHANNEL)
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to sign bytes (2)")
+	}
+
+	resp, err := s.l.Read(TEZOS_CHANNEL)
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to read bytes signature")
+	}
+
+	return encodeDeviceSignature(s.l.Curve, resp)
+}
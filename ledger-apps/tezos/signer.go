@@ -0,0 +1,26 @@
+package tezos
+
+// Signer is the signing interface of a TezosLedger. Applications which depend on
+// Signer, rather than on *TezosLedger, can be tested without hardware by
+// substituting a fake which returns deterministic keys and signatures.
+type Signer interface {
+	// Public key (edpk...) and public key hash (tz1...) of the key used to sign
+	GetPublicKey() (string, string, error)
+
+	// Signature of arbitrary bytes, including any watermark
+	SignBytes(bytesToSign []byte) (string, error)
+
+	// Consensus operations
+	SignBlock(blockBytes, chainID string) (SignOperationOutput, error)
+	SignEndorsement(endorsementBytes, chainID string) (SignOperationOutput, error)
+	SignPreendorsement(preendorsementBytes, chainID string) (SignOperationOutput, error)
+	SignNonce(nonceBytes string, chainID string) (SignOperationOutput, error)
+
+	// Manager operations
+	SignReveal(revealBytes string) (SignOperationOutput, error)
+	SignTransaction(trxBytes string) (SignOperationOutput, error)
+	SignSetDelegate(delegateBytes string) (SignOperationOutput, error)
+	SignWithBranch(branch, contentsHex string) (SignOperationOutput, error)
+}
+
+var _ Signer = (*TezosLedger)(nil)
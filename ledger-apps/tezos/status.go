@@ -0,0 +1,98 @@
+package tezos
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	ledger "github.com/bakingbacon/goledger"
+)
+
+// DeviceStatus is a point-in-time snapshot of the device, for monitoring
+type DeviceStatus struct {
+	Version           string     `json:"version"`
+	CommitHash        string     `json:"commit_hash"`
+	AuthorizedKeyPath string     `json:"authorized_key_path"`
+	Watermarks        Watermarks `json:"watermarks"`
+
+	// Reason each field which could not be retrieved failed, keyed by field name
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// Collects the app version, commit hash, authorized key path, and watermarks in one
+// go. Every field is attempted; if any fail, the fields which succeeded are still
+// populated, the failures are recorded in Errors, and an error naming them is returned.
+func (l *TezosLedger) Status() (DeviceStatus, error) {
+
+	var status DeviceStatus
+	var err error
+
+	failed := make(map[string]string)
+
+	if status.Version, err = l.GetVersion(); err != nil {
+		failed["Version"] = err.Error()
+	}
+
+	if status.CommitHash, err = l.GetCommitHash(); err != nil {
+		failed["CommitHash"] = err.Error()
+	}
+
+	if status.AuthorizedKeyPath, err = l.GetAuthorizedKeyPath(); err != nil {
+		failed["AuthorizedKeyPath"] = err.Error()
+	}
+
+	if status.Watermarks, err = l.GetWatermarks(); err != nil {
+		failed["Watermarks"] = err.Error()
+	}
+
+	if len(failed) == 0 {
+		return status, nil
+	}
+
+	status.Errors = failed
+
+	fields := make([]string, 0, len(failed))
+	for field := range failed {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	return status, errors.Errorf("Incomplete status; unable to get %s", strings.Join(fields, ", "))
+}
+
+// BakingStatus describes whether the device is authorized to bake, with which key,
+// and on which chain, for dashboards
+type BakingStatus struct {
+	Authorized bool          `json:"authorized"`
+	Key        AuthorizedKey `json:"key"`
+	Watermarks Watermarks    `json:"watermarks"`
+}
+
+// Collects the authorized baking key, and the chain and watermarks it bakes at, in
+// one go. If no key is authorized, Authorized is false and Key is empty; this is
+// not an error. Apps which cannot report the curve of the key report the path only.
+func (l *TezosLedger) BakingStatus() (BakingStatus, error) {
+
+	var status BakingStatus
+
+	key, err := l.GetAuthorizedKey()
+	if errors.Is(err, ledger.ErrUnsupportedIns) {
+		key.Path, err = l.GetAuthorizedKeyPath()
+	}
+
+	switch {
+	case err == nil:
+		status.Authorized = true
+		status.Key = key
+	case errors.Is(err, ErrNotAuthorized):
+	default:
+		return BakingStatus{}, errors.Wrap(err, "Unable to get authorized key")
+	}
+
+	if status.Watermarks, err = l.GetWatermarks(); err != nil {
+		return BakingStatus{}, errors.Wrap(err, "Unable to get baking setup")
+	}
+
+	return status, nil
+}
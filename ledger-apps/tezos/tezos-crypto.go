@@ -1,10 +1,15 @@
 package tezos
 
 import (
+	"bytes"
+	"crypto/elliptic"
 	"encoding/hex"
 	"fmt"
-	"github.com/Messer4/base58check"
+	"math/big"
+	"strings"
+
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 
 	goledger "github.com/bakingbacon/goledger"
 )
@@ -20,10 +25,14 @@ var (
 
 	edskprefix  goledger.Prefix = []byte{43, 246, 78, 7}
 	edsigprefix goledger.Prefix = []byte{9, 245, 205, 134, 18}
+	spsigprefix goledger.Prefix = []byte{13, 115, 101, 19, 63}
+	p2sigprefix goledger.Prefix = []byte{54, 240, 44, 52}
 	sigprefix   goledger.Prefix = []byte{4, 130, 43}
 
 	edsk2prefix goledger.Prefix = []byte{13, 15, 58, 7}
 	edpkprefix  goledger.Prefix = []byte{13, 15, 37, 217}
+	sppkprefix  goledger.Prefix = []byte{3, 254, 226, 86}
+	p2pkprefix  goledger.Prefix = []byte{3, 178, 139, 127}
 	edeskprefix goledger.Prefix = []byte{7, 90, 60, 179, 41}
 
 	branchprefix      goledger.Prefix = []byte{1, 52}
@@ -32,37 +41,110 @@ var (
 	endorsementprefix goledger.Prefix = []byte{2}
 	genericopprefix   goledger.Prefix = []byte{3}
 	networkprefix     goledger.Prefix = []byte{87, 82, 0}
+	operationprefix   goledger.Prefix = []byte{5, 116}
+	noncehashprefix   goledger.Prefix = []byte{69, 220, 169}
+
+	tenderbakeblockprefix       goledger.Prefix = []byte{0x11}
+	preendorsementprefix        goledger.Prefix = []byte{0x12}
+	tenderbakeendorsementprefix goledger.Prefix = []byte{0x13}
+)
+
+// Consensus algorithm of the protocol being baked, which determines the watermark
+// the device expects to find on blocks and endorsements
+//   Emmy       (up to protocol 011 Hangzhou):  block 0x01, endorsement 0x02
+//   Tenderbake (from protocol 012 Ithaca):     block 0x11, preendorsement 0x12, endorsement 0x13
+type ConsensusEra int
+
+const (
+	Emmy ConsensusEra = iota
+	Tenderbake
 )
 
-// SignOperationOutput contains an operation with the signature appended, and the signature
+var (
+	ErrNoPreendorsements = errors.New("Preendorsements do not exist before Tenderbake")
+	ErrHashMismatch   = errors.New("Hash reported by device does not match signed bytes")
+	ErrNotTestChain   = errors.New("Chain id is the device's main chain; not a test chain")
+	ErrMainChainUnset = errors.New("Device main chain is unset; test chain watermark cannot be enforced")
+	ErrChainMismatch  = errors.New("Chain id does not match the device's main chain")
+	ErrInvalidNonce   = errors.New("Seed nonce must be 32 bytes")
+)
+
+// SignOperationOutput contains an operation with the signature appended, the signature,
+// and the hash (op...) of the signed operation. The hash is only set for operations
+// signed with the generic watermark, which are injected; blocks and consensus
+// operations are not identified by an operation hash, and leave it empty.
 type SignOperationOutput struct {
-	SignedOperation string
-	Signature       string
-	EDSig           string
+	SignedOperation string `json:"signed_operation"`
+	Signature       string `json:"signature"`
+	EDSig           string `json:"edsig"`
+	OperationHash   string `json:"operation_hash,omitempty"`
 }
 
-// Helper function to return the decoded signature
-func decodeSignature(signature string) (string, error) {
+// Summarizes the output for logging; the hash, signature, and size of the signed
+// operation, rather than its full hex
+func (o SignOperationOutput) String() string {
 
-	decBytes, err := base58check.Decode(signature)
-	if err != nil {
-		return "", errors.Wrap(err, "failed to decode signature")
+	if o.OperationHash == "" {
+		return fmt.Sprintf("signed with %s (%d bytes)", o.EDSig, len(o.SignedOperation)/2)
 	}
 
-	decodedSigHex := hex.EncodeToString(decBytes)
+	return fmt.Sprintf("%s signed with %s (%d bytes)",
+		o.OperationHash, o.EDSig, len(o.SignedOperation)/2)
+}
 
-	// sanity
-	if len(decodedSigHex) > 10 {
-		decodedSigHex = decodedSigHex[10:]
-	} else {
-		return "", errors.Wrap(err, "decoded signature is invalid length")
+// Helper function to return the decoded signature (edsig/spsig1/p2sig/sig) as hex
+func decodeSignature(signature string) (string, error) {
+
+	name, ok := DetectPrefix(signature)
+	if !ok {
+		return "", errors.New("failed to decode signature")
 	}
 
-	return decodedSigHex, nil
+	switch name {
+	case "edsig", "spsig1", "p2sig", "sig":
+	default:
+		return "", errors.Errorf("not a signature: %s", name)
+	}
+
+	sigPrefix, _ := PrefixFor(name)
+
+	return hex.EncodeToString(goledger.B58cdecode(signature, sigPrefix)), nil
 }
 
 func (t *TezosLedger) SignBlock(blockBytes, chainID string) (SignOperationOutput, error) {
-	return t.signGeneric(blockprefix, blockBytes, chainID)
+
+	if t.CheckChainID {
+		if err := t.checkMainChain(blockBytes, chainID); err != nil {
+			return SignOperationOutput{}, err
+		}
+	}
+
+	if t.Guard != nil {
+
+		level, round, err := blockLevelRound(blockBytes)
+		if err != nil {
+			return SignOperationOutput{}, errors.Wrap(err, "Unable to parse block level")
+		}
+
+		if err := t.Guard.Check(BlockWatermark, level, round); err != nil {
+			return SignOperationOutput{}, err
+		}
+	}
+
+	return t.signGeneric(t.blockWatermark(), blockBytes, chainID)
+}
+
+// Signs a block on the test chain active during a protocol migration. The device
+// selects which high-level watermark to enforce by comparing the chain id embedded
+// in the signed bytes with its configured main chain, so this verifies that
+// testChainID is distinct from the main chain before signing.
+func (t *TezosLedger) SignTestChainBlock(blockBytes, testChainID string) (SignOperationOutput, error) {
+
+	if err := t.checkTestChain(testChainID); err != nil {
+		return SignOperationOutput{}, err
+	}
+
+	return t.signGeneric(t.blockWatermark(), blockBytes, testChainID)
 }
 
 func (t *TezosLedger) SignSetDelegate(delegateBytes string) (SignOperationOutput, error) {
@@ -70,7 +152,57 @@ func (t *TezosLedger) SignSetDelegate(delegateBytes string) (SignOperationOutput
 }
 
 func (t *TezosLedger) SignEndorsement(endorsementBytes, chainID string) (SignOperationOutput, error) {
-	return t.signGeneric(endorsementprefix, endorsementBytes, chainID)
+
+	if t.Guard != nil {
+
+		kind, level, round, err := endorsementLevelRound(endorsementBytes)
+		if err != nil {
+			return SignOperationOutput{}, errors.Wrap(err, "Unable to parse endorsement level")
+		}
+
+		if err := t.Guard.Check(kind, level, round); err != nil {
+			return SignOperationOutput{}, err
+		}
+	}
+
+	return t.signGeneric(t.endorsementWatermark(), endorsementBytes, chainID)
+}
+
+// Signs an endorsement on the test chain active during a protocol migration.
+// See SignTestChainBlock()
+func (t *TezosLedger) SignTestChainEndorsement(endorsementBytes, testChainID string) (SignOperationOutput, error) {
+
+	if err := t.checkTestChain(testChainID); err != nil {
+		return SignOperationOutput{}, err
+	}
+
+	return t.signGeneric(t.endorsementWatermark(), endorsementBytes, testChainID)
+}
+
+// Signs a Tenderbake preendorsement. Returns ErrNoPreendorsements if Era is Emmy
+func (t *TezosLedger) SignPreendorsement(preendorsementBytes, chainID string) (SignOperationOutput, error) {
+
+	if t.Era != Tenderbake {
+		return SignOperationOutput{}, ErrNoPreendorsements
+	}
+
+	if t.Guard != nil {
+
+		kind, level, round, err := endorsementLevelRound(preendorsementBytes)
+		if err != nil {
+			return SignOperationOutput{}, errors.Wrap(err, "Unable to parse preendorsement level")
+		}
+
+		if kind != PreendorsementWatermark {
+			return SignOperationOutput{}, errors.New("Not a preendorsement")
+		}
+
+		if err := t.Guard.Check(kind, level, round); err != nil {
+			return SignOperationOutput{}, err
+		}
+	}
+
+	return t.signGeneric(preendorsementprefix, preendorsementBytes, chainID)
 }
 
 func (t *TezosLedger) SignNonce(nonceBytes string, chainID string) (SignOperationOutput, error) {
@@ -85,7 +217,75 @@ func (t *TezosLedger) SignTransaction(trxBytes string) (SignOperationOutput, err
 	return t.signGeneric(genericopprefix, trxBytes, "")
 }
 
+// Signs a generic operation (ie: transaction, reveal, delegation) given the branch and
+// the forged contents separately, as most forging pipelines produce them. The branch
+// is a block hash (B...), and contentsHex must NOT already begin with it. The bytes
+// signed, and the signed operation returned, are assembled in the order
+//   branch (32) | contents | signature (64)
+// with the generic operation watermark prepended to the bytes signed only.
+func (t *TezosLedger) SignWithBranch(branch, contentsHex string) (SignOperationOutput, error) {
+
+	branchBytes, err := forgeBranch(branch)
+	if err != nil {
+		return SignOperationOutput{}, err
+	}
+
+	return t.signGeneric(genericopprefix, hex.EncodeToString(branchBytes) + contentsHex, "")
+}
+
+// Signs a generic operation, like SignTransaction(), given the forged bytes without
+// the watermark. The device is asked to return the hash of the bytes it signed, which
+// is verified against a hash computed locally. Returns ErrHashMismatch if they
+// differ, which indicates corruption in transit or a firmware bug.
+func (t *TezosLedger) SignVerified(bytesToSign []byte) (SignOperationOutput, error) {
+
+	verifiedSigner := func(b []byte) (string, error) {
+
+		signature, deviceHash, err := t.SignBytesWithHash(b)
+		if err != nil {
+			return "", err
+		}
+
+		localHash, err := goledger.Blake2b(b, 32)
+		if err != nil {
+			return "", err
+		}
+
+		if !bytes.Equal(localHash, deviceHash) {
+			return "", ErrHashMismatch
+		}
+
+		return signature, nil
+	}
+
+	return signOperation(t.presignWrap(verifiedSigner), genericopprefix, hex.EncodeToString(bytesToSign), "")
+}
+
 func (t *TezosLedger) signGeneric(opPrefix goledger.Prefix, incOpHex, chainID string) (SignOperationOutput, error) {
+	return signOperation(t.presignWrap(t.SignBytes), opPrefix, incOpHex, chainID)
+}
+
+// Helper function to run PreSign, if set, on the exact bytes about to be signed,
+// before handing them to signer. Every path which signs must go through this.
+func (t *TezosLedger) presignWrap(signer func([]byte) (string, error)) func([]byte) (string, error) {
+
+	if t.PreSign == nil {
+		return signer
+	}
+
+	return func(b []byte) (string, error) {
+
+		if err := t.PreSign(hex.EncodeToString(b)); err != nil {
+			return "", errors.Wrap(err, "Signing refused by PreSign")
+		}
+
+		return signer(b)
+	}
+}
+
+// Builds the bytes to sign from the operation prefix, optional chain id, and operation
+// hex, then signs them using the provided signer (ie: SignBytes)
+func signOperation(signer func([]byte) (string, error), opPrefix goledger.Prefix, incOpHex, chainID string) (SignOperationOutput, error) {
 
 	// Base bytes of operation; all ops begin with prefix
 	var opBytes = opPrefix
@@ -113,7 +313,7 @@ func (t *TezosLedger) signGeneric(opPrefix goledger.Prefix, incOpHex, chainID st
 	//fmt.Println("ToSignBytes: ", opBytes)
 	//fmt.Println("ToSignByHex: ", hex.EncodeToString(opBytes))
 
-	edSignature, err := t.SignBytes(opBytes) // returns edsig... (string)
+	edSignature, err := signer(opBytes) // returns edsig... (string)
 	if err != nil {
 		return SignOperationOutput{}, errors.Wrap(err, "failed signer")
 	}
@@ -125,16 +325,426 @@ func (t *TezosLedger) signGeneric(opPrefix goledger.Prefix, incOpHex, chainID st
 	}
 	//fmt.Println("DecodedSign: ", decodedSig)
 
-	return SignOperationOutput{
-		SignedOperation: fmt.Sprintf("%s%s", incOpHex, decodedSig),
+	signedOperation := AssembleInjection(incOpHex, decodedSig)
+
+	output := SignOperationOutput{
+		SignedOperation: signedOperation,
 		Signature: decodedSig,
 		EDSig: edSignature,
-	}, nil
+	}
+
+	// Only generic operations are injected, and so have an operation hash
+	if bytes.Equal(opPrefix, genericopprefix) {
+
+		output.OperationHash, err = operationHash(signedOperation)
+		if err != nil {
+			return SignOperationOutput{}, errors.Wrap(err, "failed to hash signed operation")
+		}
+	}
+
+	return output, nil
+}
+
+// Returns the length in bytes of a signature by a key of the given curve, as it is
+// appended to a signed operation, or 0 for an unknown curve. This is 64 for all
+// current Tezos curves; ed25519 signatures are 64 bytes, and secp256k1 and p256
+// signatures are the raw r (32) | s (32) form. The device returns secp signatures
+// DER encoded, which is variable length (typically 70 to 72 bytes), and they must
+// be converted before being appended.
+func SignatureLength(curve Curve) int {
+
+	switch curve {
+	case ED25519, BIP32_ED25519, SECP256K1, SECP256R1:
+		return 64
+	}
+
+	return 0
+}
+
+// Returns the signed operation hex expected by a node's /injection/operation, given the
+// forged operation hex and the signature. The signature must be the raw hex, as in
+// SignOperationOutput.Signature, not the b58 edsig... form; decode that first.
+func AssembleInjection(opHex, signatureRawHex string) string {
+	return opHex + signatureRawHex
+}
+
+// Inverse of the concatenation performed when signing; splits a signed operation into
+// the operation hex and the signature hex. This relies on the signature being a fixed
+// SignatureLength() bytes, which is not true of DER encoded signatures.
+func SplitSignedOperation(signedHex string) (string, string, error) {
+
+	sigHexLen := SignatureLength(ED25519) * 2
+
+	if _, err := hex.DecodeString(signedHex); err != nil {
+		return "", "", errors.Wrap(err, "Invalid signed operation")
+	}
+
+	if len(signedHex) <= sigHexLen {
+		return "", "", errors.New("Signed operation too short")
+	}
+
+	split := len(signedHex) - sigHexLen
+
+	return signedHex[:split], signedHex[split:], nil
+}
+
+// Verifies that signedHex is opHex followed by a signature of the length expected
+// for curve, guarding against malformed concatenations, such as of a DER encoded
+// signature, or of a signature to the wrong operation
+func CheckSignedOperation(opHex, signedHex string, curve Curve) error {
+
+	sigLen := SignatureLength(curve)
+	if sigLen == 0 {
+		return errors.Errorf("Unknown curve 0x%02x", uint8(curve))
+	}
+
+	if !strings.HasPrefix(strings.ToLower(signedHex), strings.ToLower(opHex)) {
+		return errors.New("Signed operation does not begin with the operation")
+	}
+
+	if got := len(signedHex) - len(opHex); got != sigLen*2 {
+		return errors.Errorf("Invalid signature length %d; expected %d bytes", got/2, sigLen)
+	}
+
+	return nil
+}
+
+// Helper function to compute the operation hash (op...) of a signed operation
+func operationHash(signedOpHex string) (string, error) {
+
+	signedOpBytes, err := hex.DecodeString(signedOpHex)
+	if err != nil {
+		return "", err
+	}
+
+	hash, err := goledger.Blake2b(signedOpBytes, 32)
+	if err != nil {
+		return "", err
+	}
+
+	return goledger.B58cencode(hash, operationprefix), nil
 }
 
 
-// Helper function to convert a public key to a public key hash
-func pkhFromPkBytes(pk []byte) (string, error) {
+// Helper function to select the block watermark for the configured era
+func (t *TezosLedger) blockWatermark() goledger.Prefix {
+	if t.Era == Tenderbake {
+		return tenderbakeblockprefix
+	}
+	return blockprefix
+}
+
+// Helper function to select the endorsement watermark for the configured era
+func (t *TezosLedger) endorsementWatermark() goledger.Prefix {
+	if t.Era == Tenderbake {
+		return tenderbakeendorsementprefix
+	}
+	return endorsementprefix
+}
+
+// Helper function to ensure a block is being signed for the chain the device is set up
+// to bake on. The block header carries no chain id, so it is only checked to be
+// well formed.
+func (t *TezosLedger) checkMainChain(blockBytes, chainID string) error {
+
+	if name, ok := DetectPrefix(chainID); !ok || name != "Net" {
+		return errors.Errorf("Invalid chain id %q", chainID)
+	}
+
+	if level, _, err := blockLevelRound(blockBytes); err != nil || level == 0 {
+		return errors.Errorf("Invalid block header (level %d): %v", level, err)
+	}
+
+	_, _, mainChainID, err := t.GetBakingSetup()
+	if err != nil {
+		return errors.Wrap(err, "Unable to query baking setup")
+	}
+
+	// A main chain of all zeros means the device treats every chain as main
+	mainChainIdBytes := goledger.B58cdecode(mainChainID, networkprefix)
+	if bytes.Equal(mainChainIdBytes, make([]byte, 4)) {
+		return ErrMainChainUnset
+	}
+
+	if mainChainID != chainID {
+		return errors.Wrapf(ErrChainMismatch, "signing for %s, device is set up for %s", chainID, mainChainID)
+	}
+
+	return nil
+}
+
+// Helper function to ensure the device will enforce the test chain watermark,
+// and not the main chain watermark, when signing for testChainID
+func (t *TezosLedger) checkTestChain(testChainID string) error {
+
+	_, _, mainChainID, err := t.GetBakingSetup()
+	if err != nil {
+		return errors.Wrap(err, "Unable to query baking setup")
+	}
+
+	// A main chain of all zeros means the device treats every chain as main
+	mainChainIdBytes := goledger.B58cdecode(mainChainID, networkprefix)
+	if bytes.Equal(mainChainIdBytes, make([]byte, 4)) {
+		return ErrMainChainUnset
+	}
+
+	if mainChainID == testChainID {
+		return ErrNotTestChain
+	}
+
+	return nil
+}
+
+// Computes the implicit account address (tz1/tz2/tz3) of a public key (edpk/sppk/p2pk)
+// Returns address, or error
+func AddressFromPublicKey(pk string) (string, error) {
+
+	name, ok := DetectPrefix(pk)
+	if !ok {
+		return "", errors.New("Unknown public key prefix")
+	}
+
+	var addrPrefix goledger.Prefix
+
+	switch name {
+	case "edpk":
+		addrPrefix = tz1prefix
+	case "sppk":
+		addrPrefix = tz2prefix
+	case "p2pk":
+		addrPrefix = tz3prefix
+	default:
+		return "", errors.Errorf("Not a public key: %s", name)
+	}
+
+	keyPrefix, _ := PrefixFor(name)
+	pkBytes := goledger.B58cdecode(pk, keyPrefix)
+
+	return pkhFromPkBytesWithPrefix(pkBytes, addrPrefix)
+}
+
+// Public key format tags; the first byte of a key returned by the device
+const (
+	pkTagEven         uint8 = 0x02 // Compressed, even Y. Always used for ed25519
+	pkTagOdd          uint8 = 0x03 // Compressed, odd Y
+	pkTagUncompressed uint8 = 0x04 // Uncompressed; X | Y follow
+)
+
+// Format tags which keys on each curve are returned with, across app versions.
+// ed25519 keys are always 0x02 | key; secp256k1 and p256 keys are uncompressed on
+// some versions and compressed on others.
+var devicePublicKeyTags = map[Curve][]uint8{
+	ED25519:       {pkTagEven},
+	BIP32_ED25519: {pkTagEven},
+	SECP256K1:     {pkTagUncompressed, pkTagEven, pkTagOdd},
+	SECP256R1:     {pkTagUncompressed, pkTagEven, pkTagOdd},
+}
+
+// Helper function to check tag is one which keys on curve are known to be returned with
+func knownKeyTag(curve Curve, tag uint8) bool {
+
+	for _, t := range devicePublicKeyTags[curve] {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Helper function to encode the public key returned by the device for the given
+// curve. The first byte is a format tag. ed25519 keys are always returned as
+// 0x02 | key. Depending on app version, secp256k1 and p256 keys are returned either
+// uncompressed as 0x04 | X | Y, which must be compressed, or already compressed as
+// 0x02 or 0x03 | X.
+// Returns public key (edpk/sppk/p2pk), public key hash (tz1/tz2/tz3), or error
+func encodeDevicePublicKey(curve Curve, key []byte) (string, string, error) {
+
+	if len(key) < 2 {
+		return "", "", ErrLengthZero
+	}
+
+	tag := key[0]
+
+	// A new app version may introduce a format; make it easy to spot in the logs
+	if !knownKeyTag(curve, tag) {
+		log.WithFields(log.Fields{
+			"Curve": fmt.Sprintf("0x%02x", uint8(curve)),
+			"Tag":   fmt.Sprintf("0x%02x", tag),
+		}).Warn("Unexpected public key format tag")
+	}
+
+	switch curve {
+	case ED25519, BIP32_ED25519:
+
+		if tag != pkTagEven || len(key) != 33 {
+			return "", "", errors.Errorf("Unexpected ed25519 key format 0x%02x (%d bytes)", tag, len(key))
+		}
+
+		// Skip the tag byte
+		pk := goledger.B58cencode(key[1:], edpkprefix)
+		pkh, err := pkhFromPkBytesWithPrefix(key[1:], tz1prefix)
+		return pk, pkh, err
+
+	case SECP256K1:
+
+		var compressed []byte
+
+		switch {
+		case tag == pkTagUncompressed && len(key) == 65:
+			compressed, _ = compressPublicKey(key)
+		case (tag == pkTagEven || tag == pkTagOdd) && len(key) == 33:
+			compressed = key
+		default:
+			return "", "", errors.Errorf("Unexpected secp key format 0x%02x (%d bytes)", tag, len(key))
+		}
+
+		pk := goledger.B58cencode(compressed, sppkprefix)
+		pkh, err := pkhFromPkBytesWithPrefix(compressed, tz2prefix)
+		return pk, pkh, err
+
+	case SECP256R1:
+
+		compressed, err := compressP256(key)
+		if err != nil {
+			return "", "", err
+		}
+
+		pk := goledger.B58cencode(compressed, p2pkprefix)
+		pkh, err := pkhFromPkBytesWithPrefix(compressed, tz3prefix)
+		return pk, pkh, err
+	}
+
+	return "", "", errors.Errorf("Unknown curve 0x%02x", uint8(curve))
+}
+
+// Helper function to compress an uncompressed (0x04 | X | Y) secp256k1 or p256
+// point into its 33 byte (0x02 or 0x03 | X) form, where the leading byte is
+// chosen by the parity of Y
+func compressPublicKey(uncompressed []byte) ([]byte, error) {
+
+	if len(uncompressed) != 65 || uncompressed[0] != pkTagUncompressed {
+		return nil, errors.New("Invalid uncompressed public key")
+	}
+
+	compressed := make([]byte, 33)
+	compressed[0] = pkTagEven | (uncompressed[64] & 0x01)
+	copy(compressed[1:], uncompressed[1:33])
+
+	return compressed, nil
+}
+
+// Helper function to return the 33 byte compressed form of a p256 key given in either
+// SEC1 form, verifying that the point is on the curve. A key corrupted in transit
+// would otherwise yield a valid looking, but unusable, tz3 address.
+func compressP256(key []byte) ([]byte, error) {
+
+	p256 := elliptic.P256()
+
+	var x, y *big.Int
+
+	switch {
+	case len(key) == 65 && key[0] == pkTagUncompressed:
+		x, y = elliptic.Unmarshal(p256, key)
+	case len(key) == 33 && (key[0] == pkTagEven || key[0] == pkTagOdd):
+		x, y = elliptic.UnmarshalCompressed(p256, key)
+	default:
+		return nil, errors.Errorf("Unexpected p256 key format 0x%02x (%d bytes)", key[0], len(key))
+	}
+
+	// Both return nil for a point not on the curve
+	if x == nil {
+		return nil, errors.New("Invalid p256 public key")
+	}
+
+	return elliptic.MarshalCompressed(p256, x, y), nil
+}
+
+// Field prime of secp256k1
+var secp256k1P, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC2F", 16)
+
+// Helper function to return the key returned by the device for the given curve in
+// its raw form; the 32 byte key for ed25519, or the 65 byte uncompressed SEC1 point
+// (0x04 | X | Y) for secp256k1 and p256, decompressing it if necessary
+func uncompressedPublicKey(curve Curve, key []byte) ([]byte, error) {
+
+	if len(key) < 2 {
+		return nil, ErrLengthZero
+	}
+
+	tag := key[0]
+
+	switch curve {
+	case ED25519, BIP32_ED25519:
+
+		if tag != pkTagEven || len(key) != 33 {
+			return nil, errors.Errorf("Unexpected ed25519 key format 0x%02x (%d bytes)", tag, len(key))
+		}
+
+		return key[1:], nil
+
+	case SECP256K1, SECP256R1:
+
+		switch {
+		case tag == pkTagUncompressed && len(key) == 65:
+			return key, nil
+		case (tag == pkTagEven || tag == pkTagOdd) && len(key) == 33:
+			return decompressPublicKey(curve, key)
+		}
+
+		return nil, errors.Errorf("Unexpected secp key format 0x%02x (%d bytes)", tag, len(key))
+	}
+
+	return nil, errors.Errorf("Unknown curve 0x%02x", uint8(curve))
+}
+
+// Helper function to decompress a 33 byte (0x02 or 0x03 | X) secp256k1 or p256
+// point into its 65 byte (0x04 | X | Y) form; the inverse of compressPublicKey()
+func decompressPublicKey(curve Curve, compressed []byte) ([]byte, error) {
+
+	if curve == SECP256R1 {
+
+		x, y := elliptic.UnmarshalCompressed(elliptic.P256(), compressed)
+		if x == nil {
+			return nil, errors.New("Invalid compressed public key")
+		}
+
+		return elliptic.Marshal(elliptic.P256(), x, y), nil
+	}
+
+	// secp256k1 is not provided by crypto/elliptic; y^2 = x^3 + 7, and as p = 3 mod 4,
+	// the square root is (x^3 + 7)^((p + 1) / 4)
+	x := new(big.Int).SetBytes(compressed[1:])
+	if x.Cmp(secp256k1P) >= 0 {
+		return nil, errors.New("Invalid compressed public key")
+	}
+
+	ySquared := new(big.Int).Exp(x, big.NewInt(3), secp256k1P)
+	ySquared.Add(ySquared, big.NewInt(7))
+	ySquared.Mod(ySquared, secp256k1P)
+
+	exp := new(big.Int).Add(secp256k1P, big.NewInt(1))
+	exp.Rsh(exp, 2)
+	y := new(big.Int).Exp(ySquared, exp, secp256k1P)
+
+	if new(big.Int).Exp(y, big.NewInt(2), secp256k1P).Cmp(ySquared) != 0 {
+		return nil, errors.New("Invalid compressed public key")
+	}
+
+	if y.Bit(0) != uint(compressed[0] & 0x01) {
+		y.Sub(secp256k1P, y)
+	}
+
+	uncompressed := make([]byte, 65)
+	uncompressed[0] = pkTagUncompressed
+	x.FillBytes(uncompressed[1:33])
+	y.FillBytes(uncompressed[33:])
+
+	return uncompressed, nil
+}
+
+// Helper function to convert a public key to a public key hash using the given address prefix
+func pkhFromPkBytesWithPrefix(pk []byte, addrPrefix goledger.Prefix) (string, error) {
 
 	// PKH needs only 20 byte buffer
 	pkh, err := goledger.Blake2b(pk, 20)
@@ -142,5 +752,151 @@ func pkhFromPkBytes(pk []byte) (string, error) {
 		return "", err
 	}
 
-	return goledger.B58cencode(pkh, tz1prefix), nil
+	return goledger.B58cencode(pkh, addrPrefix), nil
+}
+
+// Converts a curve specific signature (edsig/spsig1/p2sig) to the generic sig... form
+// accepted by some RPCs and tools. The 64 signature bytes are unchanged.
+// Returns generic signature, or error
+func ToGenericSignature(signature string) (string, error) {
+
+	name, ok := DetectPrefix(signature)
+	if !ok {
+		return "", errors.New("Invalid signature")
+	}
+
+	sigPrefix, _ := PrefixFor(name)
+
+	switch name {
+	case "edsig", "spsig1", "p2sig":
+		return goledger.B58cencode(goledger.B58cdecode(signature, sigPrefix), sigprefix), nil
+	}
+
+	return "", errors.Errorf("Not a curve specific signature: %s", name)
+}
+
+// Converts a generic signature (sig...) to the signature form of the given curve.
+// The curve cannot be recovered from the generic form, so must be supplied.
+// Returns curve specific signature (edsig/spsig1/p2sig), or error
+func FromGenericSignature(signature string, curve Curve) (string, error) {
+
+	if name, ok := DetectPrefix(signature); !ok || name != "sig" {
+		return "", errors.New("Not a generic signature")
+	}
+
+	sigBytes := goledger.B58cdecode(signature, sigprefix)
+
+	switch curve {
+	case ED25519, BIP32_ED25519:
+		return goledger.B58cencode(sigBytes, edsigprefix), nil
+	case SECP256K1:
+		return goledger.B58cencode(sigBytes, spsigprefix), nil
+	case SECP256R1:
+		return goledger.B58cencode(sigBytes, p2sigprefix), nil
+	}
+
+	return "", errors.Errorf("Unknown curve 0x%02x", uint8(curve))
+}
+
+// Order of the secp256k1 group
+var secp256k1N, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141", 16)
+
+// Helper function to encode the signature returned by the device for the given curve.
+// ed25519 signatures are returned raw; secp256k1 and p256 signatures are DER encoded.
+// Returns signature (edsig/spsig1/p2sig), or error
+func encodeDeviceSignature(curve Curve, sig []byte) (string, error) {
+
+	switch curve {
+	case ED25519, BIP32_ED25519:
+
+		if len(sig) != SignatureLength(curve) {
+			return "", errors.Errorf("Unexpected ed25519 signature length %d", len(sig))
+		}
+		return goledger.B58cencode(sig, edsigprefix), nil
+
+	case SECP256K1:
+		raw, err := derToRaw(sig, secp256k1N)
+		if err != nil {
+			return "", err
+		}
+		return goledger.B58cencode(raw, spsigprefix), nil
+
+	case SECP256R1:
+		raw, err := derToRaw(sig, elliptic.P256().Params().N)
+		if err != nil {
+			return "", err
+		}
+		return goledger.B58cencode(raw, p2sigprefix), nil
+	}
+
+	return "", errors.Errorf("Unknown curve 0x%02x", uint8(curve))
+}
+
+// Helper function to convert a DER encoded ECDSA signature into the 64 byte r | s form
+// used by Tezos. The device sets the low bit of the sequence tag (0x31) to carry the
+// parity of R, so either 0x30 or 0x31 is accepted.
+//   0x30 | len | 0x02 | rlen | r | 0x02 | slen | s
+// Tezos only accepts canonical, low-S signatures, so S above half the group order
+// is replaced by order - S.
+func derToRaw(der []byte, order *big.Int) ([]byte, error) {
+
+	if len(der) < 8 || der[0] & 0xfe != 0x30 || int(der[1]) + 2 > len(der) {
+		return nil, errors.New("Invalid DER signature")
+	}
+
+	// Reads one INTEGER, returning it and the remaining bytes
+	readInt := func(b []byte) (*big.Int, []byte, error) {
+
+		if len(b) < 2 || b[0] != 0x02 || int(b[1]) + 2 > len(b) || b[1] == 0 || b[1] > 33 {
+			return nil, nil, errors.New("Invalid DER integer")
+		}
+
+		return new(big.Int).SetBytes(b[2:2+b[1]]), b[2+b[1]:], nil
+	}
+
+	r, rest, err := readInt(der[2:2+der[1]])
+	if err != nil {
+		return nil, err
+	}
+
+	s, _, err := readInt(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	// Both must be in [1, order), which also guarantees they fit in 32 bytes;
+	// a 33 byte integer is only valid with a leading 0x00
+	for _, v := range []*big.Int{r, s} {
+		if v.Sign() <= 0 || v.Cmp(order) >= 0 {
+			return nil, errors.New("Invalid DER signature value")
+		}
+	}
+
+	halfOrder := new(big.Int).Rsh(order, 1)
+	if s.Cmp(halfOrder) > 0 {
+		s.Sub(order, s)
+	}
+
+	raw := make([]byte, 64)
+	r.FillBytes(raw[:32])
+	s.FillBytes(raw[32:])
+
+	return raw, nil
+}
+
+// Computes the nonce hash (nce...) which a block commits to, and which the
+// seed-nonce-revelation operation revealing nonce, signed by SignNonce(), answers
+// Returns ErrInvalidNonce if nonce is not 32 bytes
+func NonceHash(nonce []byte) (string, error) {
+
+	if len(nonce) != 32 {
+		return "", errors.Wrapf(ErrInvalidNonce, "got %d bytes", len(nonce))
+	}
+
+	hash, err := goledger.Blake2b(nonce, 32)
+	if err != nil {
+		return "", err
+	}
+
+	return goledger.B58cencode(hash, noncehashprefix), nil
 }
@@ -0,0 +1,340 @@
+package tezos
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	goledger "github.com/bakingbacon/goledger"
+)
+
+var (
+	ErrUnsupportedKind = errors.New("Unsupported operation kind")
+	ErrTruncated       = errors.New("Forged operation is truncated")
+)
+
+// Operation is a decoded, unsigned, forged operation; the branch it was forged
+// against, and its contents
+type Operation struct {
+	Branch   string    `json:"branch"`
+	Contents []Content `json:"contents"`
+}
+
+// Content is a single manager operation within an Operation. Only the fields
+// relevant to Kind are set.
+type Content struct {
+	Kind         OperationKind
+	Source       string
+	Fee          int64
+	Counter      int64
+	GasLimit     int64
+	StorageLimit int64
+
+	// Reveal
+	PublicKey string
+
+	// Transaction, without parameters
+	Amount      int64
+	Destination string
+
+	// Delegation; empty for a withdrawal
+	Delegate string
+}
+
+// RPC names of the kinds of operation which can be unforged
+var contentKindNames = map[OperationKind]string{
+	OpReveal:      "reveal",
+	OpTransaction: "transaction",
+	OpDelegation:  "delegation",
+}
+
+// Encodes the content as the node RPC does, so that it may be compared with, or
+// displayed alongside, a node's representation. Amounts and other integers are
+// strings, to avoid loss of precision in JSON numbers.
+func (c Content) MarshalJSON() ([]byte, error) {
+
+	m := map[string]string{
+		"kind":          contentKindNames[c.Kind],
+		"source":        c.Source,
+		"fee":           strconv.FormatInt(c.Fee, 10),
+		"counter":       strconv.FormatInt(c.Counter, 10),
+		"gas_limit":     strconv.FormatInt(c.GasLimit, 10),
+		"storage_limit": strconv.FormatInt(c.StorageLimit, 10),
+	}
+
+	switch c.Kind {
+	case OpReveal:
+		m["public_key"] = c.PublicKey
+
+	case OpTransaction:
+		m["amount"] = strconv.FormatInt(c.Amount, 10)
+		m["destination"] = c.Destination
+
+	case OpDelegation:
+		if c.Delegate != "" {
+			m["delegate"] = c.Delegate
+		}
+	}
+
+	return json.Marshal(m)
+}
+
+// Summarizes the operation for logging, one content per clause, ie:
+//   BL...: transaction of 1000 mutez from tz1... to KT1..., fee 1257 mutez; ...
+func (o Operation) String() string {
+
+	contents := make([]string, len(o.Contents))
+	for i, c := range o.Contents {
+		contents[i] = c.String()
+	}
+
+	return fmt.Sprintf("%s: %s", o.Branch, strings.Join(contents, "; "))
+}
+
+// Summarizes the content for logging; its kind, source, and what it does
+func (c Content) String() string {
+
+	var s string
+
+	switch c.Kind {
+	case OpReveal:
+		s = fmt.Sprintf("reveal of %s by %s", c.PublicKey, c.Source)
+
+	case OpTransaction:
+		s = fmt.Sprintf("transaction of %d mutez from %s to %s", c.Amount, c.Source, c.Destination)
+
+	case OpDelegation:
+		if c.Delegate == "" {
+			s = fmt.Sprintf("delegation withdrawal by %s", c.Source)
+		} else {
+			s = fmt.Sprintf("delegation from %s to %s", c.Source, c.Delegate)
+		}
+
+	default:
+		s = fmt.Sprintf("operation 0x%02x by %s", uint8(c.Kind), c.Source)
+	}
+
+	return fmt.Sprintf("%s, fee %d mutez", s, c.Fee)
+}
+
+// Decodes forged operation bytes, as signed by SignWithBranch() or produced by
+// a node's forge RPC, into the branch (B...) and the list of contents:
+//   branch (32) | content | content ...
+// Supports reveals, delegations, and transactions without parameters.
+// Returns operation, or error
+func UnforgeOperation(opHex string) (Operation, error) {
+
+	b, err := hex.DecodeString(opHex)
+	if err != nil {
+		return Operation{}, errors.Wrap(err, "Invalid operation hex")
+	}
+
+	if len(b) < 32 {
+		return Operation{}, ErrTruncated
+	}
+
+	op := Operation{
+		Branch: goledger.B58cencode(b[:32], branchprefix),
+	}
+
+	u := &unforger{b[32:]}
+
+	for len(u.b) > 0 {
+
+		c, err := u.content()
+		if err != nil {
+			return Operation{}, errors.Wrapf(err, "Unable to unforge content %d", len(op.Contents))
+		}
+
+		op.Contents = append(op.Contents, c)
+	}
+
+	return op, nil
+}
+
+// Holds the bytes remaining to be decoded
+type unforger struct {
+	b []byte
+}
+
+// Helper function to consume n bytes
+func (u *unforger) take(n int) ([]byte, error) {
+
+	if len(u.b) < n {
+		return nil, ErrTruncated
+	}
+
+	taken := u.b[:n]
+	u.b = u.b[n:]
+
+	return taken, nil
+}
+
+// Helper function to decode a single manager operation
+func (u *unforger) content() (Content, error) {
+
+	tag, err := u.take(1)
+	if err != nil {
+		return Content{}, err
+	}
+
+	c := Content{Kind: OperationKind(tag[0])}
+
+	switch c.Kind {
+	case OpReveal, OpTransaction, OpDelegation:
+	default:
+		return Content{}, errors.Wrapf(ErrUnsupportedKind, "0x%02x", tag[0])
+	}
+
+	if c.Source, err = u.implicitAddress(); err != nil {
+		return Content{}, errors.Wrap(err, "Invalid source")
+	}
+
+	// fee, counter, gas_limit, storage_limit are all zarith encoded
+	for _, v := range []*int64{&c.Fee, &c.Counter, &c.GasLimit, &c.StorageLimit} {
+		if *v, err = u.zarith(); err != nil {
+			return Content{}, err
+		}
+	}
+
+	switch c.Kind {
+	case OpReveal:
+		c.PublicKey, err = u.publicKey()
+
+	case OpTransaction:
+		if c.Amount, err = u.zarith(); err != nil {
+			return Content{}, err
+		}
+
+		if c.Destination, err = u.contractId(); err != nil {
+			return Content{}, errors.Wrap(err, "Invalid destination")
+		}
+
+		// Parameters are optional; 0x00 = none, 0xff = present
+		var flag []byte
+		if flag, err = u.take(1); err == nil && flag[0] != 0x00 {
+			err = errors.Wrap(ErrUnsupportedKind, "transaction parameters")
+		}
+
+	case OpDelegation:
+
+		// Delegate is optional; 0x00 = none, 0xff = present
+		var flag []byte
+		if flag, err = u.take(1); err == nil && flag[0] == 0xff {
+			c.Delegate, err = u.implicitAddress()
+		}
+	}
+
+	if err != nil {
+		return Content{}, err
+	}
+
+	return c, nil
+}
+
+// Helper function to decode a 21 byte tag + public key hash into tz1/tz2/tz3;
+// the inverse of forgeImplicitAddress()
+func (u *unforger) implicitAddress() (string, error) {
+
+	b, err := u.take(21)
+	if err != nil {
+		return "", err
+	}
+
+	var addrPrefix goledger.Prefix
+
+	switch b[0] {
+	case 0x00:
+		addrPrefix = tz1prefix
+	case 0x01:
+		addrPrefix = tz2prefix
+	case 0x02:
+		addrPrefix = tz3prefix
+	default:
+		return "", errors.Errorf("Unknown address tag 0x%02x", b[0])
+	}
+
+	return goledger.B58cencode(b[1:], addrPrefix), nil
+}
+
+// Helper function to decode a 22 byte contract id, which is either
+//   0x00 | implicit address (21)
+//   0x01 | contract hash (20) | 0x00
+func (u *unforger) contractId() (string, error) {
+
+	tag, err := u.take(1)
+	if err != nil {
+		return "", err
+	}
+
+	switch tag[0] {
+	case 0x00:
+		return u.implicitAddress()
+
+	case 0x01:
+		b, err := u.take(21)
+		if err != nil {
+			return "", err
+		}
+		return goledger.B58cencode(b[:20], ktprefix), nil
+	}
+
+	return "", errors.Errorf("Unknown contract tag 0x%02x", tag[0])
+}
+
+// Helper function to decode a tag + public key into edpk/sppk/p2pk
+func (u *unforger) publicKey() (string, error) {
+
+	tag, err := u.take(1)
+	if err != nil {
+		return "", err
+	}
+
+	length, keyPrefix := 33, sppkprefix
+
+	switch tag[0] {
+	case 0x00:
+		length, keyPrefix = 32, edpkprefix
+	case 0x01:
+	case 0x02:
+		keyPrefix = p2pkprefix
+	default:
+		return "", errors.Errorf("Unknown public key tag 0x%02x", tag[0])
+	}
+
+	b, err := u.take(length)
+	if err != nil {
+		return "", err
+	}
+
+	return goledger.B58cencode(b, keyPrefix), nil
+}
+
+// Helper function to decode a zarith encoded non-negative integer;
+// the inverse of forgeZarith()
+func (u *unforger) zarith() (int64, error) {
+
+	var v int64
+
+	for shift := uint(0); ; shift += 7 {
+
+		if shift > 56 {
+			return 0, errors.New("Zarith value overflows int64")
+		}
+
+		b, err := u.take(1)
+		if err != nil {
+			return 0, err
+		}
+
+		v |= int64(b[0] & 0x7f) << shift
+
+		if b[0] & 0x80 == 0 {
+			return v, nil
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package tezos
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	goledger "github.com/bakingbacon/goledger"
+)
+
+// Kinds of consensus operation tracked by a WatermarkGuard
+type WatermarkKind int
+
+const (
+	BlockWatermark WatermarkKind = iota
+	EndorsementWatermark
+	PreendorsementWatermark
+)
+
+// ErrWatermark is returned by a WatermarkGuard when asked to sign at a level/round
+// which is not strictly greater than the last one signed for the same kind
+type ErrWatermark struct {
+	Kind      WatermarkKind
+	Level     uint32
+	Round     uint32
+	LastLevel uint32
+	LastRound uint32
+}
+
+func (e *ErrWatermark) Error() string {
+	return fmt.Sprintf("Refusing to sign level %d round %d; already signed level %d round %d",
+		e.Level, e.Round, e.LastLevel, e.LastRound)
+}
+
+type watermark struct {
+	level uint32
+	round uint32
+}
+
+// WatermarkGuard is an optional, client-side double-signing guard. It records the
+// last level and round signed for each kind of consensus operation and refuses to
+// sign anything not strictly greater. It complements, but does not replace, the
+// high-level watermarks enforced by the device.
+//
+// Assign a guard to TezosLedger.Guard to have SignBlock(), SignEndorsement(), and
+// SignPreendorsement() consult it before calling the device. A WatermarkGuard is
+// safe for concurrent use.
+type WatermarkGuard struct {
+	mu   sync.Mutex
+	last map[WatermarkKind]watermark
+}
+
+// Returns an empty guard which allows signing at any level
+func NewWatermarkGuard() *WatermarkGuard {
+	return &WatermarkGuard{
+		last: make(map[WatermarkKind]watermark),
+	}
+}
+
+// Returns a guard seeded with the device's main chain high watermark. Apps predating
+// Tenderbake do not report a round, so signing at that level is only allowed for
+// rounds above 0.
+func (l *TezosLedger) NewWatermarkGuard() (*WatermarkGuard, error) {
+
+	wm, err := l.GetWatermarks()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to seed watermark guard")
+	}
+
+	g := NewWatermarkGuard()
+	for _, kind := range []WatermarkKind{BlockWatermark, EndorsementWatermark, PreendorsementWatermark} {
+		g.last[kind] = watermark{wm.Main.Level, wm.Main.Round}
+	}
+
+	return g, nil
+}
+
+// HighWatermark is the highest level, and round within it, signed by the device
+type HighWatermark struct {
+	Level uint32
+	Round uint32
+}
+
+// Watermarks are the high watermarks enforced by the device. The device tracks a
+// single watermark per chain, shared by blocks, endorsements, and preendorsements,
+// which it will not sign at or below.
+type Watermarks struct {
+	Main    HighWatermark
+	Test    HighWatermark
+	ChainID string
+
+	// Whether the app reported rounds; false for apps predating Tenderbake,
+	// in which case both rounds are 0
+	HasRound bool
+}
+
+// Returns the high watermarks of the main and test chains, including rounds
+// if the open app tracks them, and the main chain id
+func (l *TezosLedger) GetWatermarks() (Watermarks, error) {
+
+	apdu := &TzApdu{
+		GetBakingHLW,
+		0x00,
+		0x00,
+		nil,
+	}
+
+	_, err := l.Write(apdu, TEZOS_CHANNEL)
+	if err != nil {
+		return Watermarks{}, errors.Wrap(err, "Unable to write HLW request")
+	}
+
+	resp, err := l.Read(TEZOS_CHANNEL)
+	if err != nil {
+		return Watermarks{}, errors.Wrap(err, "Unable to read HLW reply")
+	}
+
+	return parseWatermarks(resp)
+}
+
+// Helper function to parse the reply to GetBakingHLW, which varies by app version
+//   legacy:     main level (4) | test level (4) | chain id (4)
+//   tenderbake: main level (4) | main round (4) | test level (4) | test round (4) | chain id (4)
+func parseWatermarks(resp []byte) (Watermarks, error) {
+
+	var wm Watermarks
+
+	switch {
+	case len(resp) >= 20:
+		wm.Main = HighWatermark{binary.BigEndian.Uint32(resp[0:4]), binary.BigEndian.Uint32(resp[4:8])}
+		wm.Test = HighWatermark{binary.BigEndian.Uint32(resp[8:12]), binary.BigEndian.Uint32(resp[12:16])}
+		wm.ChainID = goledger.B58cencode(resp[16:20], networkprefix)
+		wm.HasRound = true
+
+	case len(resp) >= 12:
+		wm.Main = HighWatermark{binary.BigEndian.Uint32(resp[0:4]), 0}
+		wm.Test = HighWatermark{binary.BigEndian.Uint32(resp[4:8]), 0}
+		wm.ChainID = goledger.B58cencode(resp[8:12], networkprefix)
+
+	default:
+		return Watermarks{}, errors.New("Not enough data returned")
+	}
+
+	return wm, nil
+}
+
+// Sets Era from the open app: apps which report rounds with their watermarks sign
+// Tenderbake blocks and endorsements, others Emmy ones. Costs one exchange, so call
+// it once after connecting, rather than before each signature.
+// Returns the era detected, or error
+func (l *TezosLedger) DetectEra() (ConsensusEra, error) {
+
+	wm, err := l.GetWatermarks()
+	if err != nil {
+		return l.Era, errors.Wrap(err, "Unable to detect consensus era")
+	}
+
+	l.Era = Emmy
+	if wm.HasRound {
+		l.Era = Tenderbake
+	}
+
+	return l.Era, nil
+}
+
+// Reports whether the device would sign an operation of kind at level and round
+// on the main chain, without attempting to sign; a pre-flight check to avoid a
+// guaranteed rejection (ErrBelowWatermark)
+func (l *TezosLedger) WatermarkAllows(kind WatermarkKind, level, round uint32) (bool, error) {
+
+	wm, err := l.GetWatermarks()
+	if err != nil {
+		return false, err
+	}
+
+	return wm.Allows(kind, level, round), nil
+}
+
+// Reports whether the main chain watermark allows signing an operation of kind at
+// level and round. Per Tenderbake, anything above the watermark's level, or at its
+// level in a later round, is allowed. At the watermark itself, blocks are refused but
+// (pre)endorsements are allowed, as they follow the block they endorse. The device
+// also refuses a second (pre)endorsement there, but does not report having signed
+// one, so this cannot be detected. Apps predating Tenderbake do not report rounds,
+// so only levels are compared.
+func (wm Watermarks) Allows(kind WatermarkKind, level, round uint32) bool {
+
+	if !wm.HasRound {
+		round = 0
+	}
+
+	switch {
+	case level != wm.Main.Level:
+		return level > wm.Main.Level
+	case round != wm.Main.Round:
+		return round > wm.Main.Round
+	}
+
+	return kind != BlockWatermark
+}
+
+// Records level/round for kind if strictly greater than the last recorded,
+// otherwise returns *ErrWatermark
+func (g *WatermarkGuard) Check(kind WatermarkKind, level, round uint32) error {
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if last, ok := g.last[kind]; ok {
+		if level < last.level || (level == last.level && round <= last.round) {
+			return &ErrWatermark{kind, level, round, last.level, last.round}
+		}
+	}
+
+	g.last[kind] = watermark{level, round}
+
+	return nil
+}
+
+// Helper function to extract the level and round from a forged block header
+//   level (4) | proto (1) | predecessor (32) | timestamp (8) | validation pass (1) |
+//   operations hash (32) | fitness (4 byte length + list of 4 byte length prefixed items)
+// The round, when present, is the last item of the fitness
+func blockLevelRound(blockHex string) (uint32, uint32, error) {
+
+	b, err := hex.DecodeString(blockHex)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	const fitnessOffset = 4 + 1 + 32 + 8 + 1 + 32
+
+	if len(b) < fitnessOffset+4 {
+		return 0, 0, errors.New("Block header too short")
+	}
+
+	level := binary.BigEndian.Uint32(b[:4])
+
+	fitnessLen := int(binary.BigEndian.Uint32(b[fitnessOffset : fitnessOffset+4]))
+	fitness := b[fitnessOffset+4:]
+	if len(fitness) < fitnessLen {
+		return 0, 0, errors.New("Block fitness too short")
+	}
+	fitness = fitness[:fitnessLen]
+
+	// Walk to the last fitness item
+	var item []byte
+	for len(fitness) >= 4 {
+
+		itemLen := int(binary.BigEndian.Uint32(fitness[:4]))
+		if len(fitness) < 4+itemLen {
+			return 0, 0, errors.New("Invalid block fitness")
+		}
+
+		item = fitness[4 : 4+itemLen]
+		fitness = fitness[4+itemLen:]
+	}
+
+	// Pre-tenderbake fitness has no round
+	var round uint32
+	if fitnessLen > 0 && len(item) == 4 {
+		round = binary.BigEndian.Uint32(item)
+	}
+
+	return level, round, nil
+}
+
+// Helper function to extract the kind, level, and round from forged endorsement bytes
+//   legacy:     branch (32) | 0x00 | level (4)
+//   tenderbake: branch (32) | tag (1) | slot (2) | level (4) | round (4) | payload hash (32)
+func endorsementLevelRound(endorsementHex string) (WatermarkKind, uint32, uint32, error) {
+
+	b, err := hex.DecodeString(endorsementHex)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	if len(b) < 33 {
+		return 0, 0, 0, errors.New("Endorsement too short")
+	}
+
+	switch OperationKind(b[32]) {
+	case OpEndorsement:
+		if len(b) < 37 {
+			return 0, 0, 0, errors.New("Endorsement too short")
+		}
+		return EndorsementWatermark, binary.BigEndian.Uint32(b[33:37]), 0, nil
+
+	case OpPreendorsement, OpTenderbakeEndorsement:
+		if len(b) < 43 {
+			return 0, 0, 0, errors.New("Endorsement too short")
+		}
+
+		kind := EndorsementWatermark
+		if OperationKind(b[32]) == OpPreendorsement {
+			kind = PreendorsementWatermark
+		}
+		return kind, binary.BigEndian.Uint32(b[35:39]), binary.BigEndian.Uint32(b[39:43]), nil
+	}
+
+	return 0, 0, 0, errors.Errorf("Unknown endorsement tag 0x%02x", b[32])
+}
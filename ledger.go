@@ -1,61 +1,504 @@
 package ledger
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/bakingbacon/hid"
 	"github.com/pkg/errors"
 	
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	// Number of times a failed HID read is retried before giving up
+	DefaultReadRetries = 3
+
+	// Maximum number of raw bytes accumulated while reading a single response
+	DefaultMaxResponseSize = 4096
+
+	// Maximum length of a marshaled APDU written in a single exchange; the
+	// header (5) plus the most CDATA a one byte length can describe (255)
+	DefaultMaxRequestSize = 5 + 255
+
+	// Size of HID frames exchanged with the device; a USB HID report
+	hidPacketSize = 64
+
+	// Number of times a request answered with a transient status is retried
+	DefaultTransientRetries = 2
+
+	// Environment variable naming the HID path of the device for Get() to open
+	EnvHIDPath = "LEDGER_HID_PATH"
+
+	// Number of attempts made to open the device, and the delay between them
+	DefaultOpenAttempts = 3
+	openRetryDelay      = 200 * time.Millisecond
+
+	// Bounds of the delay between polls in GetWithTimeout()
+	getPollMinDelay = 100 * time.Millisecond
+	getPollMaxDelay = 2 * time.Second
+)
+
+var (
+	ErrNoDevice = errors.New("Ledger plugged in? Unlocked? Correct app open?")
+
+	// Status words retried by default; 0x6f00 is reported intermittently under load
+	DefaultTransientStatuses = []int{0x6f00}
+)
+
+// NoDeviceError is returned by Get() when no matching device is found. It matches
+// ErrNoDevice with errors.Is(), and lists the serials of any devices which were
+// enumerated, but did not match.
+type NoDeviceError struct {
+	Skipped []string
+}
+
+func (e *NoDeviceError) Error() string {
+	if len(e.Skipped) > 0 {
+		return fmt.Sprintf("%s Non-matching devices: %s", ErrNoDevice, strings.Join(e.Skipped, ", "))
+	}
+	return ErrNoDevice.Error()
+}
+
+func (e *NoDeviceError) Is(target error) bool {
+	return target == ErrNoDevice
+}
+
+// HIDDevice is the subset of hid.Device used to exchange frames with the device.
+// It allows a Recorder or Replayer to stand in for the real device.
+type HIDDevice interface {
+	Write(b []byte) (int, error)
+	Read(b []byte) (int, error)
+	ReadTimeout(b []byte, timeout int) (int, error)
+	SetNonBlocking(nonblocking bool) (int, error)
+	Close() error
+}
+
 type Ledger struct {
 	Device  hid.DeviceInfo
-	Dev     *hid.Device
+	Dev     HIDDevice
 	BipPath []byte
+
+	// Path given to SetBipPath(), from which BipPath was encoded; kept so the
+	// path can be re-applied to a new connection after reconnecting
+	BipPathString string
+
+	// Number of additional attempts made when the HID stack reports a
+	// read failure. Set to 0 to disable retrying.
+	ReadRetries int
+
+	// Maximum number of raw bytes, including framing, read for a single
+	// response before giving up. Set to 0 to disable the limit.
+	MaxResponseSize int
+
+	// Maximum length of a marshaled APDU, before framing, accepted by
+	// Write(). Set to 0 to disable the limit.
+	MaxRequestSize int
+
+	// Status words which indicate a transient failure rather than a rejected
+	// request, and the number of times Read() retries a request answered with
+	// one of them. Permanent failures, such as ErrUserDenied, should not be
+	// listed. Set TransientRetries to 0 to disable retrying. Requests are
+	// not retried within a multi-APDU exchange; see BeginMultiPart().
+	TransientStatuses []int
+	TransientRetries  int
+
+	// Leaves the device in non-blocking mode while waiting on the user to sign,
+	// relying on Read()'s timeout instead. Some Windows HID stacks hang when the
+	// blocking mode is toggled.
+	DisableBlockingToggle bool
+
+	// Last request written, for retrying
+	lastRequest []byte
+
+	// Depth of BeginMultiPart() calls not yet ended
+	multiPart int
+
+	// Closed by Abort(), and left closed until the next exchange begins
+	abortMu sync.Mutex
+	abortCh chan struct{}
+	aborted bool
+
+	// Opens a new handle to the same device, replacing one left mid-exchange
+	// by Abort(). Set by Get().
+	open func() (HIDDevice, error)
 }
 
+// Settings for Get() which are not part of the device's identity
+type getConfig struct {
+	openAttempts int
+	serial       string
+	matcher      DeviceMatcher
+}
+
+// DeviceMatcher reports whether an enumerated HID interface is the one to open
+type DeviceMatcher func(hid.DeviceInfo) bool
+
+// GetOption overrides one of the defaults used by Get()
+type GetOption func(*getConfig)
+
+// Sets the number of attempts made to open the device (default DefaultOpenAttempts).
+// Opening may fail transiently, as on macOS when another process briefly has
+// exclusive access to the device.
+func WithOpenAttempts(attempts int) GetOption {
+	return func(c *getConfig) { c.openAttempts = attempts }
+}
+
+// Only considers the device with the given serial number, for choosing between
+// several connected devices
+func WithSerial(serial string) GetOption {
+	return func(c *getConfig) { c.serial = serial }
+}
+
+// Replaces the usage page and interface number rules of Get() with matcher; the
+// first interface it accepts is opened. For platform specific selection, ie: by
+// path pattern, on setups where the default rules pick the wrong interface.
+func WithMatcher(matcher DeviceMatcher) GetOption {
+	return func(c *getConfig) { c.matcher = matcher }
+}
+
+// Use the HID library to open the first Ledger device matching vendorId and productId.
+// A device may expose several HID interfaces, not all of which carry APDU traffic, so
+// candidates are chosen in priority order:
+//   1. the first interface whose usage page is usagePage
+//   2. the first interface whose interface number is interfaceNumber
+// Interfaces enumerated more than once under the same path, as happens with hidraw
+// on some Linux systems, are only considered once. WithMatcher() replaces these
+// rules with a caller supplied one.
+// If the environment variable LEDGER_HID_PATH is set, the device at that path is opened
+// instead, as is useful where the path is fixed by a udev rule or container mapping.
+// Symlinks are resolved. WithSerial() and WithMatcher() still apply: Get() fails if
+// the device at the path does not satisfy them, or is not enumerated to check.
+func Get(vendorId, productId, interfaceNumber, usagePage uint16, opts ...GetOption) (*Ledger, error) {
+
+	cfg := getConfig{
+		openAttempts: DefaultOpenAttempts,
+	}
 
-func Get(vendorId, productId, interfaceNumber, usagePage uint16) (*Ledger, error) {
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 
 	var tempDevice hid.DeviceInfo
 
+	var err error
+
+	// An explicitly configured path skips choosing between interfaces
+	if path := os.Getenv(EnvHIDPath); path != "" {
+
+		tempDevice, err = pathDevice(hid.Enumerate(vendorId, productId), path, cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		if tempDevice.VendorID == 0 {
+			tempDevice.VendorID = vendorId
+			tempDevice.ProductID = productId
+		}
+
+	} else {
+
+		tempDevice, err = findDevice(vendorId, productId, interfaceNumber, usagePage, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// open device
+	dev, err := openDevice(tempDevice, cfg.openAttempts)
+	if err != nil {
+		return nil, err
+	}
+
+	if r, err := dev.SetNonBlocking(true); r == -1 {
+		return nil, errors.Wrap(err, "Could not set non-blocking")
+	}
+
+	l := &Ledger{
+		Device:          tempDevice,
+		Dev:             dev,
+		ReadRetries:     DefaultReadRetries,
+		MaxResponseSize: DefaultMaxResponseSize,
+		MaxRequestSize:  DefaultMaxRequestSize,
+
+		TransientStatuses: append([]int(nil), DefaultTransientStatuses...),
+		TransientRetries:  DefaultTransientRetries,
+	}
+
+	l.open = func() (HIDDevice, error) {
+
+		dev, err := openDevice(tempDevice, cfg.openAttempts)
+		if err != nil {
+			return nil, err
+		}
+
+		if r, err := dev.SetNonBlocking(true); r == -1 {
+			dev.Close()
+			return nil, errors.Wrap(err, "Could not set non-blocking")
+		}
+
+		return dev, nil
+	}
+
+	return l, nil
+}
+
+// Helper function to enumerate devices, choosing one as described by Get()
+func findDevice(vendorId, productId, interfaceNumber, usagePage uint16, cfg getConfig) (hid.DeviceInfo, error) {
+
 	// Ledger vendor: 0x2c97 / 11415
+	devices := hid.Enumerate(vendorId, productId)
 
-	for _, dev := range hid.Enumerate(vendorId, productId) {
-		
+	for _, dev := range devices {
 		log.WithFields(log.Fields{
 			"ProductName": dev.Product, "Manuf": dev.Manufacturer, "Path": dev.Path, "VendorID": dev.VendorID, "ProductID": dev.ProductID,
+			"Serial": dev.Serial, "Interface": dev.Interface, "UsagePage": dev.UsagePage,
 		}).Debug("HID Device")
+	}
+
+	return selectDevice(devices, interfaceNumber, usagePage, cfg)
+}
+
+// Helper function to choose among the enumerated devices, in the priority order
+// described by Get()
+func selectDevice(devices []hid.DeviceInfo, interfaceNumber, usagePage uint16, cfg getConfig) (hid.DeviceInfo, error) {
+
+	var byMatcher, byUsagePage, byInterface hid.DeviceInfo
+	var skipped []string
+
+	seen := make(map[string]bool)
+
+	for _, dev := range devices {
+
+		// Skip duplicates
+		if seen[dev.Path] {
+			continue
+		}
+		seen[dev.Path] = true
 		
-		if dev.Interface == int(interfaceNumber) || dev.UsagePage == usagePage {
-			tempDevice = dev
-			break
+		switch {
+		case cfg.serial != "" && dev.Serial != cfg.serial:
+			skipped = append(skipped, dev.Serial)
+		case cfg.matcher != nil:
+			if byMatcher.Path == "" && cfg.matcher(dev) {
+				byMatcher = dev
+			} else {
+				skipped = append(skipped, dev.Serial)
+			}
+		case dev.UsagePage == usagePage && byUsagePage.Path == "":
+			byUsagePage = dev
+		case dev.Interface == int(interfaceNumber) && byInterface.Path == "":
+			byInterface = dev
+		default:
+			skipped = append(skipped, dev.Serial)
 		}
 	}
 
+	// Prefer the caller's match, then an exact usage page match
+	tempDevice := byMatcher
 	if tempDevice.Path == "" {
-		return nil, errors.New("Ledger plugged in? Unlocked?")
+		tempDevice = byUsagePage
 	}
-	
-	// open device
-	dev, err := tempDevice.Open()
-	if err != nil {
-		return nil, errors.Wrap(err, "Failed to open")
+	if tempDevice.Path == "" {
+		tempDevice = byInterface
 	}
 
-	if r, err := dev.SetNonBlocking(true); r == -1 {
-		return nil, errors.Wrap(err, "Could not set non-blocking")
+	if tempDevice.Path == "" {
+
+		// Tell the user which devices were seen, but didn't match
+		return tempDevice, &NoDeviceError{skipped}
+	}
+
+	return tempDevice, nil
+}
+
+// Helper function to describe the device at path, as enumerated, so that its serial is
+// known and Attached() recognises it. A serial or matcher from cfg must accept the
+// device; one which cannot be checked, as the path is not enumerated, is an error.
+func pathDevice(devices []hid.DeviceInfo, path string, cfg getConfig) (hid.DeviceInfo, error) {
+
+	resolved := resolvePath(path)
+
+	for _, dev := range devices {
+
+		if resolvePath(dev.Path) != resolved {
+			continue
+		}
+
+		if cfg.serial != "" && dev.Serial != cfg.serial {
+			return hid.DeviceInfo{}, errors.Errorf("%s=%s is device %s, not %s", EnvHIDPath, path, dev.Serial, cfg.serial)
+		}
+
+		if cfg.matcher != nil && !cfg.matcher(dev) {
+			return hid.DeviceInfo{}, errors.Errorf("%s=%s is not accepted by the matcher", EnvHIDPath, path)
+		}
+
+		return dev, nil
+	}
+
+	if cfg.serial != "" || cfg.matcher != nil {
+		return hid.DeviceInfo{}, errors.Wrapf(ErrNoDevice, "%s=%s is not enumerated; cannot check serial or matcher", EnvHIDPath, path)
+	}
+
+	return hid.DeviceInfo{Path: path}, nil
+}
+
+// Helper function to resolve symlinks in path, such as one created by a udev rule,
+// returning path unchanged if it cannot be resolved
+func resolvePath(path string) string {
+
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+
+	return path
+}
+
+// Helper function to open the device, making up to attempts attempts
+func openDevice(info hid.DeviceInfo, attempts int) (*hid.Device, error) {
+
+	var err error
+
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	for i := 0; i < attempts; i++ {
+
+		if i > 0 {
+			time.Sleep(openRetryDelay)
+		}
+
+		var dev *hid.Device
+		if dev, err = info.Open(); err == nil {
+			return dev, nil
+		}
+
+		log.WithError(err).WithField("Serial", info.Serial).Debug("Failed to open device")
+	}
+
+	return nil, errors.Wrapf(err, "Failed to open device %s after %d attempts", info.Serial, attempts)
+}
+
+// Same as Get(), but if no matching device is found, keeps polling until the device
+// appears or ctx is done. Useful immediately after the ledger is unlocked, or an app
+// is opened, as the USB interface may not yet be ready.
+// When ctx is done, the error wraps ctx.Err(), so that errors.Is() tells a deadline
+// from a cancellation, and describes the last failure to find the device.
+func GetWithTimeout(ctx context.Context, vendorId, productId, interfaceNumber, usagePage uint16, opts ...GetOption) (*Ledger, error) {
+	return pollGet(ctx, func() (*Ledger, error) {
+		return Get(vendorId, productId, interfaceNumber, usagePage, opts...)
+	})
+}
+
+// Helper function to call get until it finds a device, fails otherwise, or ctx is done
+func pollGet(ctx context.Context, get func() (*Ledger, error)) (*Ledger, error) {
+
+	delay := getPollMinDelay
+
+	for {
+
+		l, err := get()
+		if err == nil || !errors.Is(err, ErrNoDevice) {
+			return l, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrap(ctx.Err(), err.Error())
+		case <-time.After(delay):
+		}
+
+		// Back off between polls
+		delay = delay * 2
+		if delay > getPollMaxDelay {
+			delay = getPollMaxDelay
+		}
+	}
+}
+
+// Closes the device and zeroes the derivation path. Safe to call more than once.
+func (l *Ledger) Close() error {
+
+	// Don't leave derivation state lying around in memory
+	for i := range l.BipPath {
+		l.BipPath[i] = 0
 	}
+	l.BipPath = nil
+	l.BipPathString = ""
 
-	return &Ledger{
-		tempDevice,
-		dev,
-		nil,
-	}, nil
+	if l.Dev == nil {
+		return nil
+	}
+
+	if err := l.Dev.Close(); err != nil {
+		return errors.Wrapf(err, "Failed to close device %s", l.Serial())
+	}
+
+	return nil
+}
+
+// Reports whether the device is still enumerated by the HID stack, ie: has not
+// been unplugged, or locked, since it was opened
+func (l *Ledger) Attached() bool {
+	return attached(hid.Enumerate(l.Device.VendorID, l.Device.ProductID), l.Device.Path)
+}
+
+// Helper function to check whether path, or the path a symlink resolves to, is
+// among the enumerated devices
+func attached(devices []hid.DeviceInfo, path string) bool {
+
+	resolved := resolvePath(path)
+
+	for _, dev := range devices {
+		if resolvePath(dev.Path) == resolved {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Switches the device between blocking and non-blocking reads, as
+// HIDDevice.SetNonBlocking() does, unless DisableBlockingToggle is set
+func (l *Ledger) SetNonBlocking(nonblocking bool) (int, error) {
+
+	if l.DisableBlockingToggle {
+		return 0, nil
+	}
+
+	return l.Dev.SetNonBlocking(nonblocking)
+}
+
+// Marks the start of an exchange spanning several APDUs, such as a bip path followed
+// by chunks of data to sign, until the matching EndMultiPart(). Read() does not retry
+// transient failures meanwhile: resending only the last APDU cannot succeed once the
+// device has dropped those before it; retry the whole exchange instead. Calls nest.
+func (l *Ledger) BeginMultiPart() {
+
+	// The exchange starts afresh, like a single Write()
+	if l.multiPart == 0 {
+		l.clearAbort()
+	}
+
+	l.multiPart++
+}
+
+// Marks the end of the exchange started by BeginMultiPart()
+func (l *Ledger) EndMultiPart() {
+	if l.multiPart > 0 {
+		l.multiPart--
+	}
 }
 
-func (l *Ledger) Close() {
-	l.Dev.Close()
+// Returns the serial number reported by the device
+func (l *Ledger) Serial() string {
+	return l.Device.Serial
 }
 
 func (l *Ledger) SetBipPath(bipPath string) (error) {
@@ -68,6 +511,7 @@ func (l *Ledger) SetBipPath(bipPath string) (error) {
 	// Other functions that need this path can use it
 	l.BipPath = make([]byte, len(encodedBP))
 	l.BipPath = encodedBP
+	l.BipPathString = bipPath
 
 	return nil
 }
@@ -0,0 +1,219 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bakingbacon/hid"
+)
+
+func TestNoDeviceError(t *testing.T) {
+
+	var err error = &NoDeviceError{[]string{"0001"}}
+
+	if !errors.Is(err, ErrNoDevice) {
+		t.Errorf("Expecting NoDeviceError to match ErrNoDevice")
+	}
+
+	expected := "Ledger plugged in? Unlocked? Correct app open? Non-matching devices: 0001"
+	if err.Error() != expected {
+		t.Errorf("Expecting %s; Got %s", expected, err.Error())
+	}
+
+	if (&NoDeviceError{}).Error() != ErrNoDevice.Error() {
+		t.Errorf("Expecting %s; Got %s", ErrNoDevice, (&NoDeviceError{}).Error())
+	}
+}
+
+func TestSelectDevice(t *testing.T) {
+
+	const (
+		iface     = 0
+		usagePage = 0xffa0
+	)
+
+	// U2F interface, then APDU interface; a platform without usage pages; and
+	// hidraw listing the same interface twice
+	u2f := hid.DeviceInfo{Path: "1:4:01", Serial: "0001", Interface: 1, UsagePage: 0xf1d0}
+	apdu := hid.DeviceInfo{Path: "1:4:00", Serial: "0001", Interface: 0, UsagePage: usagePage}
+	bare := hid.DeviceInfo{Path: "2:4:00", Serial: "0002", Interface: 0}
+	dup := hid.DeviceInfo{Path: "1:4:01", Serial: "0001", Interface: 0, UsagePage: usagePage}
+	other := hid.DeviceInfo{Path: "3:4:00", Serial: "0003", Interface: 0, UsagePage: usagePage}
+
+	byPath := func(path string) DeviceMatcher {
+		return func(dev hid.DeviceInfo) bool { return dev.Path == path }
+	}
+
+	tests := []struct {
+		name     string
+		devices  []hid.DeviceInfo
+		cfg      getConfig
+		expected string
+		skipped  []string
+	}{
+		{"usage page", []hid.DeviceInfo{u2f, apdu}, getConfig{}, apdu.Path, nil},
+		{"usage page before interface", []hid.DeviceInfo{bare, apdu}, getConfig{}, apdu.Path, nil},
+		{"interface without usage page", []hid.DeviceInfo{u2f, bare}, getConfig{}, bare.Path, nil},
+		{"matcher replaces rules", []hid.DeviceInfo{apdu, bare}, getConfig{matcher: byPath(bare.Path)}, bare.Path, nil},
+		{"serial", []hid.DeviceInfo{other, apdu}, getConfig{serial: "0001"}, apdu.Path, nil},
+
+		// The duplicate path is considered once, as the U2F interface it first was
+		{"duplicate path", []hid.DeviceInfo{u2f, dup}, getConfig{}, "", []string{"0001"}},
+		{"matcher refuses", []hid.DeviceInfo{apdu, bare}, getConfig{matcher: byPath("none")}, "", []string{"0001", "0002"}},
+		{"serial missing", []hid.DeviceInfo{apdu, other}, getConfig{serial: "0009"}, "", []string{"0001", "0003"}},
+		{"none", nil, getConfig{}, "", nil},
+	}
+
+	for _, tt := range tests {
+
+		dev, err := selectDevice(tt.devices, iface, usagePage, tt.cfg)
+
+		if tt.expected != "" {
+			if err != nil || dev.Path != tt.expected {
+				t.Errorf("%s: Expecting %s; Got %s (%v)", tt.name, tt.expected, dev.Path, err)
+			}
+			continue
+		}
+
+		var noDevice *NoDeviceError
+		if !errors.As(err, &noDevice) {
+			t.Errorf("%s: Expecting NoDeviceError; Got %v", tt.name, err)
+			continue
+		}
+
+		if strings.Join(noDevice.Skipped, ",") != strings.Join(tt.skipped, ",") {
+			t.Errorf("%s: Expecting skipped %v; Got %v", tt.name, tt.skipped, noDevice.Skipped)
+		}
+	}
+}
+
+func TestPollGet(t *testing.T) {
+
+	// Found on the third poll
+	var polls int
+	found := &Ledger{}
+
+	l, err := pollGet(context.Background(), func() (*Ledger, error) {
+		polls++
+		if polls < 3 {
+			return nil, &NoDeviceError{}
+		}
+		return found, nil
+	})
+
+	if err != nil || l != found || polls != 3 {
+		t.Errorf("Expecting device on poll 3; Got %v after %d polls (%v)", l, polls, err)
+	}
+
+	// Other failures are not retried
+	polls = 0
+	failed := errors.New("Failed to open device")
+
+	if _, err := pollGet(context.Background(), func() (*Ledger, error) {
+		polls++
+		return nil, failed
+	}); err != failed || polls != 1 {
+		t.Errorf("Expecting %v after 1 poll; Got %v after %d polls", failed, err, polls)
+	}
+
+	// A deadline and a cancellation remain distinguishable
+	missing := func() (*Ledger, error) {
+		return nil, &NoDeviceError{[]string{"0002"}}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150 * time.Millisecond)
+	defer cancel()
+
+	_, err = pollGet(ctx, missing)
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		t.Errorf("Expecting context.DeadlineExceeded; Got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), "Non-matching devices: 0002") {
+		t.Errorf("Expecting last failure in message; Got %s", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := pollGet(ctx, missing); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expecting context.Canceled; Got %v", err)
+	}
+}
+
+func TestPathDevice(t *testing.T) {
+
+	// A udev rule's symlink to the device node
+	dir := t.TempDir()
+	node := filepath.Join(dir, "hidraw3")
+	link := filepath.Join(dir, "ledger-baker")
+
+	f, err := os.Create(node)
+	if err != nil {
+		t.Fatalf("Cannot create node: %s\n", err)
+	}
+	f.Close()
+
+	if err := os.Symlink(node, link); err != nil {
+		t.Fatalf("Cannot create symlink: %s\n", err)
+	}
+
+	devices := []hid.DeviceInfo{
+		{Path: filepath.Join(dir, "hidraw2"), Serial: "0002"},
+		{Path: node, Serial: "0001"},
+	}
+
+	rejectAll := func(hid.DeviceInfo) bool { return false }
+
+	tests := []struct {
+		path     string
+		cfg      getConfig
+		serial   string
+		fails    bool
+		noDevice bool
+	}{
+		{link, getConfig{}, "0001", false, false},
+		{link, getConfig{serial: "0001"}, "0001", false, false},
+		{node, getConfig{}, "0001", false, false},
+		{"/dev/missing", getConfig{}, "", false, false},
+
+		// Another device's serial, or one the matcher refuses
+		{link, getConfig{serial: "0002"}, "", true, false},
+		{link, getConfig{matcher: rejectAll}, "", true, false},
+
+		// Nothing to check the serial against
+		{"/dev/missing", getConfig{serial: "0001"}, "", true, true},
+	}
+
+	for i, tt := range tests {
+
+		dev, err := pathDevice(devices, tt.path, tt.cfg)
+
+		switch {
+		case !tt.fails && (err != nil || dev.Serial != tt.serial):
+			t.Errorf("#%d: Expecting serial %q; Got %q (%v)", i, tt.serial, dev.Serial, err)
+		case tt.fails && err == nil:
+			t.Errorf("#%d: Expecting error; Got device %q", i, dev.Serial)
+		case tt.noDevice && !errors.Is(err, ErrNoDevice):
+			t.Errorf("#%d: Expecting ErrNoDevice; Got %v", i, err)
+		}
+	}
+
+	// The enumerated path is kept, so that Attached() finds it again
+	if dev, _ := pathDevice(devices, link, getConfig{}); dev.Path != node {
+		t.Errorf("Expecting %s; Got %s", node, dev.Path)
+	}
+
+	if !attached(devices, link) || !attached(devices, node) {
+		t.Errorf("Expecting symlinked device to be attached")
+	}
+
+	if attached(devices, "/dev/missing") {
+		t.Errorf("Expecting missing device not to be attached")
+	}
+}
@@ -0,0 +1,217 @@
+package ledger
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Offset of the INS byte in a written buffer;
+// report id (1) | channel (2) | tag (1) | sequence (2) | length (2) | CLA (1)
+const insOffset = 9
+
+var (
+	ErrNoFixture       = errors.New("No recorded exchange for request")
+	ErrFixtureMismatch = errors.New("Request does not match recorded exchange")
+)
+
+// Exchange is a single request written to the device, and the frames it answered with
+type Exchange struct {
+	Request  string   `json:"request"`
+	Response []string `json:"response"`
+}
+
+// Fixtures are recorded device sessions; exchanges in the order they occurred,
+// keyed by the hex INS byte of the request
+type Fixtures struct {
+	Exchanges map[string][]*Exchange `json:"exchanges"`
+}
+
+// Loads fixtures saved by Fixtures.Save()
+func LoadFixtures(path string) (*Fixtures, error) {
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read fixtures")
+	}
+
+	f := &Fixtures{}
+	if err := json.Unmarshal(b, f); err != nil {
+		return nil, errors.Wrap(err, "Unable to decode fixtures")
+	}
+
+	return f, nil
+}
+
+// Writes fixtures to path as JSON
+func (f *Fixtures) Save(path string) error {
+
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "Unable to encode fixtures")
+	}
+
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// Helper function to key a written buffer by its INS byte
+func fixtureKey(b []byte) string {
+	if len(b) <= insOffset {
+		return ""
+	}
+	return fmt.Sprintf("%02x", b[insOffset])
+}
+
+// Recorder passes frames through to a real device, recording each exchange.
+// Assign it to Ledger.Dev, then save Fixtures() once the session is done.
+type Recorder struct {
+	dev HIDDevice
+
+	mu       sync.Mutex
+	fixtures *Fixtures
+	cur      *Exchange
+}
+
+// Returns a Recorder wrapping dev
+func NewRecorder(dev HIDDevice) *Recorder {
+	return &Recorder{
+		dev: dev,
+		fixtures: &Fixtures{
+			Exchanges: make(map[string][]*Exchange),
+		},
+	}
+}
+
+// Returns the exchanges recorded so far
+func (r *Recorder) Fixtures() *Fixtures {
+	return r.fixtures
+}
+
+func (r *Recorder) Write(b []byte) (int, error) {
+
+	r.mu.Lock()
+	key := fixtureKey(b)
+	r.cur = &Exchange{Request: hex.EncodeToString(b)}
+	r.fixtures.Exchanges[key] = append(r.fixtures.Exchanges[key], r.cur)
+	r.mu.Unlock()
+
+	return r.dev.Write(b)
+}
+
+func (r *Recorder) Read(b []byte) (int, error) {
+	n, err := r.dev.Read(b)
+	r.record(b, n)
+	return n, err
+}
+
+func (r *Recorder) ReadTimeout(b []byte, timeout int) (int, error) {
+	n, err := r.dev.ReadTimeout(b, timeout)
+	r.record(b, n)
+	return n, err
+}
+
+func (r *Recorder) SetNonBlocking(nonblocking bool) (int, error) {
+	return r.dev.SetNonBlocking(nonblocking)
+}
+
+func (r *Recorder) Close() error {
+	return r.dev.Close()
+}
+
+// Helper function to record a frame read in reply to the current request
+func (r *Recorder) record(b []byte, n int) {
+
+	if n <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cur != nil {
+		r.cur.Response = append(r.cur.Response, hex.EncodeToString(b[:n]))
+	}
+}
+
+// Replayer stands in for a device, answering each request with the frames recorded
+// for it. Requests for the same INS are answered in the order they were recorded,
+// and must match the recorded request exactly.
+type Replayer struct {
+	fixtures *Fixtures
+
+	mu      sync.Mutex
+	next    map[string]int
+	pending [][]byte
+}
+
+// Returns a Replayer serving the given fixtures
+func NewReplayer(f *Fixtures) *Replayer {
+	return &Replayer{
+		fixtures: f,
+		next:     make(map[string]int),
+	}
+}
+
+func (r *Replayer) Write(b []byte) (int, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := fixtureKey(b)
+
+	exchanges := r.fixtures.Exchanges[key]
+	i := r.next[key]
+	if i >= len(exchanges) {
+		return -1, errors.Wrapf(ErrNoFixture, "INS 0x%s", key)
+	}
+
+	if exchanges[i].Request != hex.EncodeToString(b) {
+		return -1, errors.Wrapf(ErrFixtureMismatch, "INS 0x%s #%d", key, i)
+	}
+
+	r.pending = r.pending[:0]
+	for _, frame := range exchanges[i].Response {
+
+		fb, err := hex.DecodeString(frame)
+		if err != nil {
+			return -1, errors.Wrap(err, "Invalid recorded frame")
+		}
+		r.pending = append(r.pending, fb)
+	}
+
+	r.next[key]++
+
+	return len(b), nil
+}
+
+// Returns the next recorded frame, or 0 bytes read if none remain
+func (r *Replayer) Read(b []byte) (int, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.pending) == 0 {
+		return 0, nil
+	}
+
+	n := copy(b, r.pending[0])
+	r.pending = r.pending[1:]
+
+	return n, nil
+}
+
+func (r *Replayer) ReadTimeout(b []byte, timeout int) (int, error) {
+	return r.Read(b)
+}
+
+func (r *Replayer) SetNonBlocking(nonblocking bool) (int, error) {
+	return 0, nil
+}
+
+func (r *Replayer) Close() error {
+	return nil
+}
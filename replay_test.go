@@ -0,0 +1,95 @@
+package ledger
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+type testApdu []byte
+
+func (a testApdu) MarshalBinary() ([]byte, error) {
+	return a, nil
+}
+
+// Helper function to build the fixture of a single exchange, as Write() and Read() see it
+func testExchange(t *testing.T, request, response []byte) *Exchange {
+
+	l := &Ledger{}
+
+	req, err := l.wrapCommandAPDU(testChannel, request, hidPacketSize)
+	if err != nil {
+		t.Fatalf("Cannot wrap request: %s\n", err)
+	}
+
+	resp, err := l.wrapCommandAPDU(testChannel, response, hidPacketSize)
+	if err != nil {
+		t.Fatalf("Cannot wrap response: %s\n", err)
+	}
+
+	ex := &Exchange{Request: hex.EncodeToString(append([]byte{0}, req...))}
+	for i := 0; i < len(resp); i += hidPacketSize {
+		ex.Response = append(ex.Response, hex.EncodeToString(resp[i:i+hidPacketSize]))
+	}
+
+	return ex
+}
+
+func TestRecordReplay(t *testing.T) {
+
+	request := testApdu{0x80, 0x00, 0x00, 0x00, 0x00}
+	payload := make([]byte, 100)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	f := &Fixtures{
+		Exchanges: map[string][]*Exchange{
+			"00": {testExchange(t, request, append(payload, 0x90, 0x00))},
+		},
+	}
+
+	// Record a session against the replayed one; both must agree
+	rec := NewRecorder(NewReplayer(f))
+	l := &Ledger{Dev: rec}
+
+	if _, err := l.Write(request, testChannel); err != nil {
+		t.Fatalf("Cannot write: %s\n", err)
+	}
+
+	resp, err := l.Read(testChannel)
+	if err != nil {
+		t.Fatalf("Cannot read: %s\n", err)
+	}
+
+	if !bytes.Equal(resp, payload) {
+		t.Errorf("Expecting %x; Got %x", payload, resp)
+	}
+
+	recorded := rec.Fixtures().Exchanges["00"]
+	if len(recorded) != 1 || recorded[0].Request != f.Exchanges["00"][0].Request ||
+		len(recorded[0].Response) != len(f.Exchanges["00"][0].Response) {
+		t.Errorf("Recorded exchange does not match replayed exchange")
+	}
+
+	// Exchanges are only replayed once
+	if _, err := l.Write(request, testChannel); !errors.Is(err, ErrNoFixture) {
+		t.Errorf("Expecting ErrNoFixture; Got %v", err)
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+
+	f := &Fixtures{
+		Exchanges: map[string][]*Exchange{
+			"00": {testExchange(t, testApdu{0x80, 0x00, 0x00, 0x00, 0x00}, []byte{0x90, 0x00})},
+		},
+	}
+
+	l := &Ledger{Dev: NewReplayer(f)}
+
+	if _, err := l.Write(testApdu{0x80, 0x00, 0x01, 0x00, 0x00}, testChannel); !errors.Is(err, ErrFixtureMismatch) {
+		t.Errorf("Expecting ErrFixtureMismatch; Got %v", err)
+	}
+}